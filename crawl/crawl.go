@@ -3,11 +3,15 @@
 package crawl
 
 import (
+	"context"
+	"crypto/sha1"
+	"fmt"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/thatguystone/acrylic/internal/cache"
 	"golang.org/x/sync/errgroup"
@@ -15,14 +19,42 @@ import (
 
 // Crawl performs a crawl with the given config
 func Crawl(h http.Handler, opts ...Option) (Site, error) {
-	cr := newCrawler(h, opts...)
+	return CrawlContext(context.Background(), h, opts...)
+}
+
+// CrawlContext performs a crawl with the given config. If ctx is cancelled,
+// no new pages are loaded, and every page that didn't finish is reported in
+// the returned SiteError. ctx is also passed to the handler with every
+// request.
+func CrawlContext(ctx context.Context, h http.Handler, opts ...Option) (
+	Site, error) {
+
+	cr := newCrawler(ctx, h, opts...)
+	cr.loadRobots()
 
 	for _, entry := range cr.entries {
 		cr.get(entry)
 	}
 
+	for status, path := range cr.errorPages {
+		cr.getErrorPage(status, path)
+	}
+
 	cr.wg.Wait()
 
+	// Any redirect loop that a link ran into was already reported
+	if len(cr.err) == 0 {
+		cr.checkRedirects()
+	}
+
+	// Generated files need every page to exist, so don't bother if the crawl
+	// itself failed
+	if len(cr.err) == 0 {
+		for _, gen := range cr.generators {
+			gen(cr)
+		}
+	}
+
 	if len(cr.err) > 0 {
 		return Site{}, cr.err
 	}
@@ -34,20 +66,44 @@ func Crawl(h http.Handler, opts ...Option) (Site, error) {
 		return Site{}, err
 	}
 
+	cr.site.report = cr.writer.getReport()
+	if len(cr.warn) > 0 {
+		cr.site.warnings = cr.warn
+	}
+
 	return cr.site, nil
 }
 
 type crawler struct {
-	handler      http.Handler
-	entries      []*url.URL
-	output       string
-	transforms   map[string][]Transform
-	fingerprints fingerprints
-	cleanDirs    []string
-	wg           sync.WaitGroup
+	ctx            context.Context
+	handler        http.Handler
+	entries        []*url.URL
+	errorPages     map[int]string // Output paths by status
+	output         string
+	transforms     map[string][]Transform
+	htmlAttrs      htmlAttrs // Only set if customized
+	fingerprints   fingerprints
+	cleanDirs      []string
+	filter         urlFilter
+	layout         Layout
+	headers        []string // Canonical names of headers to export
+	generators     []generator
+	precompress    precompressor
+	integrity      bool
+	spill          spillConfig
+	manifest       string // Where to write the manifest; "" if disabled
+	writer         outputWriter
+	state          crawlState
+	events         eventer
+	deps           depGraph
+	slots          chan struct{} // Concurrency limiter; nil if unlimited
+	pageTimeout    time.Duration
+	strictWarnings bool
+	wg             sync.WaitGroup
 
 	mtx  sync.Mutex
 	err  SiteError
+	warn SiteError
 	site Site
 	used usedFiles
 }
@@ -55,19 +111,31 @@ type crawler struct {
 // Absolute paths to all used files and directories
 type usedFiles map[string]struct{}
 
-func newCrawler(h http.Handler, opts ...Option) *crawler {
+// A generator produces output that isn't served by the handler (eg. a
+// sitemap). Generators run after the crawl has finished, and they report
+// errors through addError.
+type generator func(cr *crawler)
+
+func newCrawler(ctx context.Context, h http.Handler, opts ...Option) *crawler {
 	cr := &crawler{
+		ctx:        ctx,
 		handler:    h,
 		output:     "./public",
 		transforms: make(map[string][]Transform),
 		fingerprints: fingerprints{
+			newHash:   sha1.New,
 			cacheFile: filepath.Join(cache.DefaultDir, "fingerprints.json.gz"),
 		},
-		err: make(SiteError),
+		spill: spillConfig{
+			threshold: defaultSpillThreshold,
+		},
+		err:  make(SiteError),
+		warn: make(SiteError),
 		site: Site{
-			urls:   make(map[string]*Page),
-			pages:  make(map[string]*Page),
-			claims: make(map[string]*Page),
+			urls:     make(map[string]*Page),
+			pages:    make(map[string]*Page),
+			claims:   make(map[string]*Page),
+			errPages: make(map[int]*Page),
 		},
 		used: make(usedFiles),
 	}
@@ -76,7 +144,10 @@ func newCrawler(h http.Handler, opts ...Option) *crawler {
 		opt.applyTo(cr)
 	}
 
+	cr.writer.init(cr.output, &cr.events)
+
 	cr.fingerprints.loadCache()
+	cr.state.init(&cr.fingerprints)
 
 	if len(cr.entries) == 0 {
 		cr.entries = []*url.URL{
@@ -87,6 +158,10 @@ func newCrawler(h http.Handler, opts ...Option) *crawler {
 	// Default transforms always come after user-supplied transforms so that the
 	// defaults may work on final, user-provided content.
 	for mediaType, ts := range defaultTransforms {
+		if mediaType == htmlType && cr.htmlAttrs != nil {
+			ts = []Transform{cr.htmlAttrs.transform}
+		}
+
 		cr.addTransforms(mediaType, ts...)
 	}
 
@@ -107,6 +182,17 @@ func (cr *crawler) addError(u url.URL, err error) {
 	cr.mtx.Unlock()
 }
 
+func (cr *crawler) addWarning(u url.URL, err error) {
+	if cr.strictWarnings {
+		cr.addError(u, err)
+		return
+	}
+
+	cr.mtx.Lock()
+	cr.warn.add(u.String(), err)
+	cr.mtx.Unlock()
+}
+
 func (cr *crawler) get(u *url.URL) *Page {
 	uu := normURL(u)
 	k := uu.String()
@@ -121,9 +207,116 @@ func (cr *crawler) get(u *url.URL) *Page {
 
 	cr.mtx.Unlock()
 
+	if !ok {
+		pg.start()
+	}
+
+	return pg
+}
+
+// lookup gets the Page for u, if it's already part of the crawl
+func (cr *crawler) lookup(u *url.URL) *Page {
+	k := normURL(u).String()
+
+	cr.mtx.Lock()
+	pg := cr.site.urls[k]
+	cr.mtx.Unlock()
+
 	return pg
 }
 
+// checkRedirects makes sure that every redirect leads somewhere. Links check
+// what they point to as they're resolved, but nothing follows redirects
+// between pages that are only ever entered (eg. "/" -> "/a" -> "/").
+func (cr *crawler) checkRedirects() {
+	for _, pg := range cr.site.urls {
+		if pg.Redirect == nil {
+			continue
+		}
+
+		_, err := pg.followRedirects(nil)
+		if err != nil {
+			pg.addError(err)
+		}
+	}
+}
+
+// crawlable determines if a link to u should be crawled, per Include, Exclude,
+// and Robots
+func (cr *crawler) crawlable(u *url.URL) bool {
+	uu := normURL(u)
+	if uu.Scheme != "" || uu.Opaque != "" || uu.Host != "" {
+		return true
+	}
+
+	return cr.filter.allowed(uu)
+}
+
+// outputPath gets the absolute path of the output file for the given url.Path
+func (cr *crawler) outputPath(urlPath string) string {
+	return absPath(filepath.Join(cr.output, outputURLPath(urlPath)))
+}
+
+// outputURLPath maps the given url.Path to the path of the file that serves
+// it, relative to the output directory.
+func outputURLPath(urlPath string) string {
+	// If going to a directory, need to add an index.html
+	if strings.HasSuffix(urlPath, "/") {
+		urlPath += "index.html"
+	}
+
+	return urlPath
+}
+
+func (cr *crawler) getErrorPage(status int, path string) *Page {
+	u := &url.URL{Path: fmt.Sprintf(errorPageURL, status)}
+
+	cr.mtx.Lock()
+
+	pg := newErrorPage(cr, u, status, cleanURLPath(path))
+	cr.site.urls[u.String()] = pg
+	cr.site.errPages[status] = pg
+
+	cr.mtx.Unlock()
+
+	pg.start()
+	return pg
+}
+
+// genPage creates an already-loaded Page for a generated file and claims its
+// output file.
+func (cr *crawler) genPage(path, mediaType string) (*Page, error) {
+	pg := &Page{
+		OrigURL:    url.URL{Path: path},
+		URL:        url.URL{Path: path},
+		OutputPath: cr.outputPath(path),
+		MediaType:  mediaType,
+		Status:     http.StatusOK,
+		cr:         cr,
+	}
+
+	err := cr.claimFile(pg, pg.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	k := pg.URL.String()
+
+	cr.mtx.Lock()
+
+	if _, ok := cr.site.urls[k]; !ok {
+		cr.site.urls[k] = pg
+	}
+
+	if _, ok := cr.site.pages[path]; !ok {
+		cr.site.pages[path] = pg
+	}
+
+	cr.mtx.Unlock()
+
+	return pg, nil
+}
+
 // Claim the given url.Path such that only 1 Page owns the path
 func (cr *crawler) claimPage(pg *Page, path string) (*Page, bool) {
 	cr.mtx.Lock()
@@ -204,6 +397,14 @@ func (cr *crawler) finish() error {
 		cr.setUsed(cr.fingerprints.cacheFile)
 	}
 
+	if cr.manifest != "" {
+		cr.setUsed(cr.manifest)
+	}
+
+	if cr.state.enabled {
+		cr.setUsed(cr.state.file)
+	}
+
 	var g errgroup.Group
 
 	for _, dir := range dirs {
@@ -214,33 +415,30 @@ func (cr *crawler) finish() error {
 	}
 
 	g.Go(func() error {
-		return cr.fingerprints.saveCache(cr.used)
+		return cr.fingerprints.saveCache(cr.used, &cr.writer)
 	})
 
+	if cr.manifest != "" {
+		g.Go(cr.writeManifest)
+	}
+
+	g.Go(cr.saveState)
+
 	return g.Wait()
 }
 
 func (cr *crawler) cleanDir(dir string) error {
-	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			// Don't fail if the root directory doesn't exist
-			if dir == path && os.IsNotExist(err) {
-				return nil
-			}
-
-			return err
-		}
-
+	return cr.writer.walk(dir, func(path string, isDir bool) error {
 		if _, ok := cr.used[path]; ok {
 			return nil
 		}
 
-		err = os.RemoveAll(path)
+		err := cr.writer.removeAll(path)
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
+		if isDir {
 			// Since the dir was removed, have to skip it, or it causes a walk
 			// error
 			return filepath.SkipDir
@@ -2,12 +2,17 @@ package crawl
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/thatguystone/acrylic/internal/testutil"
 	"github.com/thatguystone/cog/check"
@@ -51,7 +56,7 @@ func TestCrawlClean(t *testing.T) {
 	})
 	defer tmp.Remove()
 
-	cr := newCrawler(nil,
+	cr := newCrawler(context.Background(), nil,
 		Output(tmp.Path("/public")),
 		FingerprintCache(tmp.Path("/.cache/fingerprints")),
 		CleanDirs(
@@ -74,7 +79,7 @@ func TestCrawlClean(t *testing.T) {
 func TestCrawlClaimCollision(t *testing.T) {
 	c := check.New(t)
 
-	gifPrint, err := fingerprint(bytes.NewReader(testutil.GifBin))
+	gifPrint, err := fingerprint(sha1.New, bytes.NewReader(testutil.GifBin))
 	c.Must.Nil(err)
 
 	fpPath := "/img." + gifPrint + ".gif"
@@ -125,6 +130,7 @@ func TestCrawlClaimCollision(t *testing.T) {
 			defer tmp.Remove()
 
 			cr := newCrawler(
+				context.Background(),
 				mux(map[string]http.Handler{
 					"/img.gif": stringHandler{
 						contType: testutil.GifType,
@@ -227,6 +233,7 @@ func TestCrawlClaimFileDirMismatch(t *testing.T) {
 			defer tmp.Remove()
 
 			cr := newCrawler(
+				context.Background(),
 				mux(map[string]http.Handler{
 					"/index": stringHandler{
 						contType: DefaultType,
@@ -256,3 +263,156 @@ func TestCrawlClaimFileDirMismatch(t *testing.T) {
 		})
 	}
 }
+
+func TestCrawlConcurrency(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	var (
+		mtx     sync.Mutex
+		running int
+		max     int
+	)
+
+	body := `<link href="/a.css" rel="stylesheet">`
+	for i := 0; i < 10; i++ {
+		body += fmt.Sprintf(`<a href="/%d.html"></a>`, i)
+	}
+
+	_, err := Crawl(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mtx.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mtx.Unlock()
+
+			defer func() {
+				mtx.Lock()
+				running--
+				mtx.Unlock()
+			}()
+
+			time.Sleep(time.Millisecond)
+
+			h := stringHandler{
+				contType: htmlType,
+				body:     r.URL.Path,
+			}
+
+			switch r.URL.Path {
+			case "/":
+				h.body = body
+
+			case "/a.css":
+				h.contType = cssType
+				h.body = `@import "/b.css";`
+
+			case "/b.css":
+				h.contType = cssType
+				h.body = `a{}`
+			}
+
+			h.ServeHTTP(w, r)
+		}),
+		Concurrency(1),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Fingerprint(func(u *url.URL, mediaType string) bool {
+			return mediaType == cssType
+		}))
+	c.Must.Nil(err)
+
+	c.Equal(max, 1)
+}
+
+func TestCrawlContextCancel(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	_, err := CrawlContext(ctx,
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/slow/"></a>`,
+			},
+			"/slow/": http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					<-r.Context().Done()
+				}),
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.NotNil(err)
+
+	serr := err.(SiteError)
+	c.Equal(serr["/slow/"], []error{context.Canceled})
+}
+
+func TestCrawlContextCancelled(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Even without a Concurrency limit, nothing is loaded once cancelled
+	started := 0
+	_, err := CrawlContext(ctx,
+		stringHandler{
+			contType: htmlType,
+			body:     `index`,
+		},
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		Events(func(ev Event) {
+			if ev.Kind == EventRequestStart {
+				started++
+			}
+		}))
+
+	c.Equal(err, SiteError{
+		"/": {context.Canceled},
+	})
+	c.Equal(started, 0)
+}
+
+func TestCrawlPageTimeout(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	_, err := Crawl(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Ignore the request's context entirely
+			<-stuck
+		}),
+		PageTimeout(10*time.Millisecond),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+
+	c.Equal(err, SiteError{
+		"/": {PageTimeoutError{Timeout: 10 * time.Millisecond}},
+	})
+}
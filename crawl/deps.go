@@ -0,0 +1,119 @@
+package crawl
+
+import "sync"
+
+// depGraph tracks which Pages are blocked waiting on which other Pages so that
+// dependency cycles can be reported rather than deadlocking.
+//
+// Since each Page is loaded by a single goroutine, a Page can only ever be
+// waiting on one other Page at a time, so the graph is just a map of edges.
+type depGraph struct {
+	mtx   sync.Mutex
+	waits map[*Page]depEdge
+}
+
+type depEdge struct {
+	to   *Page
+	kind waitKind
+}
+
+// A waitKind is the state a Page waits for another Page to reach
+type waitKind int
+
+const (
+	// The page's final URL and output path are known
+	waitLoaded waitKind = iota
+
+	// The page's output has been rendered and won't change
+	waitDone
+)
+
+// blocks determines if a waiter of the given kind would block on the page.
+// Must be called with the mutex held.
+func (e depEdge) blocks() bool {
+	if e.kind == waitLoaded {
+		return e.to.pending
+	}
+
+	return e.to.rendering
+}
+
+// setLoaded marks the given page as loaded. Returns false if the page was
+// already loaded.
+func (g *depGraph) setLoaded(pg *Page) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if !pg.pending {
+		return false
+	}
+
+	pg.pending = false
+	return true
+}
+
+// setDone marks the given page as done. Returns false if the page was already
+// done.
+func (g *depGraph) setDone(pg *Page) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	if !pg.rendering {
+		return false
+	}
+
+	pg.rendering = false
+	return true
+}
+
+// add records that from is about to block on to. If to has already reached
+// the state being waited for, nothing is recorded and false is returned. If
+// blocking would complete a cycle, nothing is recorded and the cycle is
+// returned as an error.
+func (g *depGraph) add(from, to *Page, kind waitKind) (bool, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	edge := depEdge{to: to, kind: kind}
+	if !edge.blocks() {
+		return false, nil
+	}
+
+	cycle := []*Page{from}
+
+	// Walk everything that to is (transitively) waiting on. If the walk hits a
+	// page that isn't blocked, then everything will eventually resolve. Only if
+	// it gets back to from is there a cycle.
+	for curr, ok := edge, true; ok && curr.blocks(); curr, ok = g.waits[curr.to] {
+		if curr.to == from {
+			return false, newDependencyCycleError(cycle)
+		}
+
+		cycle = append(cycle, curr.to)
+	}
+
+	if g.waits == nil {
+		g.waits = make(map[*Page]depEdge)
+	}
+
+	g.waits[from] = edge
+	return true, nil
+}
+
+func (g *depGraph) remove(from *Page) {
+	g.mtx.Lock()
+	delete(g.waits, from)
+	g.mtx.Unlock()
+}
+
+func newDependencyCycleError(cycle []*Page) DependencyCycleError {
+	err := DependencyCycleError{
+		URLs: make([]string, len(cycle)),
+	}
+
+	for i, pg := range cycle {
+		err.URLs[i] = pg.OrigURL.String()
+	}
+
+	return err
+}
@@ -0,0 +1,133 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestDepsCycle(t *testing.T) {
+	c := check.New(t)
+
+	tests := []struct {
+		name string
+		h    http.Handler
+		urls []string
+	}{
+		{
+			name: "Self",
+			h: mux(map[string]http.Handler{
+				"/": stringHandler{
+					contType: htmlType,
+					body:     `<link href="/a.css" rel="stylesheet">`,
+				},
+				"/a.css": stringHandler{
+					contType: cssType,
+					body:     `@import "/a.css";`,
+				},
+			}),
+			urls: []string{"/a.css"},
+		},
+		{
+			name: "Loop",
+			h: mux(map[string]http.Handler{
+				"/": stringHandler{
+					contType: htmlType,
+					body:     `<link href="/a.css" rel="stylesheet">`,
+				},
+				"/a.css": stringHandler{
+					contType: cssType,
+					body:     `@import "/b.css";`,
+				},
+				"/b.css": stringHandler{
+					contType: cssType,
+					body:     `@import "/c.css";`,
+				},
+				"/c.css": stringHandler{
+					contType: cssType,
+					body:     `@import "/a.css";`,
+				},
+			}),
+			urls: []string{"/a.css", "/b.css", "/c.css"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		c.Run(test.name, func(c *check.C) {
+			tmp := testutil.NewTmpDir(c, nil)
+			defer tmp.Remove()
+
+			_, err := Crawl(test.h,
+				Output(tmp.Path("/public")),
+				FingerprintCache(tmp.Path(".cache/fingerprints")),
+				Fingerprint(func(u *url.URL, mediaType string) bool {
+					return filepath.Ext(u.Path) == ".css"
+				}))
+			c.Must.NotNil(err)
+
+			var cycles []DependencyCycleError
+			for _, errs := range err.(SiteError) {
+				for _, err := range errs {
+					if cycle, ok := err.(DependencyCycleError); ok {
+						cycles = append(cycles, cycle)
+					}
+				}
+			}
+
+			c.Must.Len(cycles, 1)
+
+			urls := append([]string(nil), cycles[0].URLs...)
+			sort.Strings(urls)
+			c.Equal(urls, test.urls)
+		})
+	}
+}
+
+func TestDepsNoFingerprintResolves(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<link href="/a.css" rel="stylesheet">`,
+			},
+			"/a.css": stringHandler{
+				contType: cssType,
+				body:     `@import "/b.css";`,
+			},
+			"/b.css": stringHandler{
+				contType: cssType,
+				body:     `@import "/a.css";`,
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.Nil(err)
+
+	c.Contains(tmp.ReadFile("/public/a.css"), "/b.css")
+	c.Contains(tmp.ReadFile("/public/b.css"), "/a.css")
+}
+
+func TestDepsCycleError(t *testing.T) {
+	c := check.New(t)
+
+	err := DependencyCycleError{URLs: []string{"/a", "/b"}}
+	c.Equal(err.Error(), `dependency cycle: /a -> /b -> /a`)
+}
+
+func TestDepsCycleErrorEmpty(t *testing.T) {
+	c := check.New(t)
+
+	c.Equal(DependencyCycleError{}.Error(), `dependency cycle`)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/thatguystone/acrylic/internal"
 	"github.com/thatguystone/cog/stringc"
@@ -56,6 +57,40 @@ func (err ResponseError) Error() string {
 	return fmt.Sprintf("http error: %d%s", err.Status, body)
 }
 
+// An ErrorPageStatusError indicates that the handler did not respond to an
+// ErrorPage's request with the expected status
+type ErrorPageStatusError struct {
+	Expected int
+	Got      int
+}
+
+func (err ErrorPageStatusError) Error() string {
+	return fmt.Sprintf(
+		"error page expected status %d, but got %d",
+		err.Expected, err.Got)
+}
+
+// A ManifestVersionError indicates that a manifest was written by a different
+// version of the crawler
+type ManifestVersionError struct {
+	Path    string
+	Version int
+}
+
+func (err ManifestVersionError) Error() string {
+	return fmt.Sprintf(
+		"manifest %q has unsupported version %d",
+		err.Path, err.Version)
+}
+
+// An ExcludedLinkError is a warning that a link points to a URL that isn't
+// crawled. See WarnExcluded.
+type ExcludedLinkError string
+
+func (err ExcludedLinkError) Error() string {
+	return fmt.Sprintf("link to %q is excluded from the crawl", string(err))
+}
+
 // A MimeTypeMismatchError indicates that content type for an extension does not
 // match the Content-Type that was returned for it.
 type MimeTypeMismatchError struct {
@@ -105,6 +140,32 @@ func (err RedirectLoopError) Error() string {
 		err.Start, err.End)
 }
 
+// A DependencyCycleError indicates that pages depend on each other in a way
+// that can never be resolved (eg. fingerprinted stylesheets that @import each
+// other: neither fingerprint can be known until the other's is).
+type DependencyCycleError struct {
+	URLs []string // Every URL in the cycle, in dependency order
+}
+
+func (err DependencyCycleError) Error() string {
+	if len(err.URLs) == 0 {
+		return "dependency cycle"
+	}
+
+	urls := append(err.URLs[:len(err.URLs):len(err.URLs)], err.URLs[0])
+	return fmt.Sprintf("dependency cycle: %s", strings.Join(urls, " -> "))
+}
+
+// A PageTimeoutError indicates that a handler took longer than the PageTimeout
+// to respond
+type PageTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (err PageTimeoutError) Error() string {
+	return fmt.Sprintf("handler did not finish within %s", err.Timeout)
+}
+
 // A TooManyRedirectsError indicates that the crawler gave up trying to follow
 // redirects because there were too many
 type TooManyRedirectsError struct {
@@ -0,0 +1,123 @@
+package crawl
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thatguystone/acrylic"
+)
+
+// An EventKind is the kind of thing an Event describes
+type EventKind int
+
+const (
+	// EventDiscovered is sent when a URL is seen for the first time
+	EventDiscovered EventKind = iota + 1
+
+	// EventRequestStart is sent right before a page's handler is called
+	EventRequestStart
+
+	// EventRequestDone is sent once a page's handler has finished. Status,
+	// Duration, and Err (if the handler didn't finish) are set.
+	EventRequestDone
+
+	// EventTransform is sent after each Transform is applied to a page.
+	// Duration is set.
+	EventTransform
+
+	// EventAlias is sent when a page turns out to be an alias of another
+	// (see Page.AliasOf)
+	EventAlias
+
+	// EventWritten is sent when a file is written or symlinked. Path is set.
+	// This covers every file, not just pages (eg. precompressed files and the
+	// Manifest), so Page isn't set.
+	EventWritten
+
+	// EventUnchanged is sent instead of EventWritten when a file already had
+	// the right contents
+	EventUnchanged
+
+	// EventCleaned is sent when the clean step removes a file or dir. Path is
+	// set.
+	EventCleaned
+)
+
+var eventKindNames = map[EventKind]string{
+	EventDiscovered:   "discovered",
+	EventRequestStart: "request start",
+	EventRequestDone:  "request done",
+	EventTransform:    "transform",
+	EventAlias:        "alias",
+	EventWritten:      "written",
+	EventUnchanged:    "unchanged",
+	EventCleaned:      "cleaned",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// An Event describes something that happened during a crawl. Only the fields
+// listed on each EventKind are set. Since the crawl is still running, Page
+// should only be used to identify the page: anything else on it may still be
+// changing.
+type Event struct {
+	Kind     EventKind
+	Page     *Page
+	Path     string // Absolute path of a file
+	Status   int
+	Duration time.Duration
+	Err      error
+}
+
+type eventer struct {
+	mtx       sync.Mutex
+	listeners []func(ev Event)
+}
+
+func (e *eventer) emit(ev Event) {
+	if e == nil || len(e.listeners) == 0 {
+		return
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	for _, l := range e.listeners {
+		l(ev)
+	}
+}
+
+// LogEvents creates an Events listener that logs every page as it finishes,
+// along with a count of pages done and pending, and errors from handlers that
+// didn't finish.
+func LogEvents(log acrylic.Logger) func(ev Event) {
+	var done, found int
+
+	return func(ev Event) {
+		switch ev.Kind {
+		case EventDiscovered:
+			if !ev.Page.IsExternal() {
+				found++
+			}
+
+		case EventRequestDone:
+			done++
+
+			msg := fmt.Sprintf("[%d/%d] %s", done, found, ev.Page.OrigURL.String())
+			if ev.Err != nil {
+				log.Error(ev.Err, msg)
+				return
+			}
+
+			log.Log(fmt.Sprintf("%s: %d in %s",
+				msg, ev.Status, ev.Duration.Round(time.Millisecond)))
+		}
+	}
+}
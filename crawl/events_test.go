@@ -0,0 +1,177 @@
+package crawl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestEvents(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/public/stale.txt": ``,
+	})
+	defer tmp.Remove()
+
+	var evs []Event
+	crawl := func() {
+		evs = nil
+
+		_, err := Crawl(
+			mux(map[string]http.Handler{
+				"/": stringHandler{
+					contType: htmlType,
+					body: `` +
+						`<a href="/page/?p=1">` +
+						`<a href="/page/?p=2">` +
+						`<a href="https://example.com">`,
+				},
+				"/page/": stringHandler{
+					contType: htmlType,
+					body:     `page`,
+				},
+			}),
+			Output(tmp.Path("/public")),
+			FingerprintCache(tmp.Path(".cache/fingerprints")),
+			Events(func(ev Event) {
+				evs = append(evs, ev)
+			}))
+		c.Must.Nil(err)
+	}
+
+	count := func(kind EventKind) (n int) {
+		for _, ev := range evs {
+			if ev.Kind == kind {
+				n++
+			}
+		}
+
+		return
+	}
+
+	crawl()
+	tmp.DumpTree()
+
+	c.Equal(count(EventDiscovered), 4)
+	c.Equal(count(EventRequestStart), 3)
+	c.Equal(count(EventRequestDone), 3)
+	c.Equal(count(EventAlias), 1)
+	c.Equal(count(EventWritten), 2)
+	c.Equal(count(EventUnchanged), 0)
+	c.Equal(count(EventCleaned), 1)
+	c.True(count(EventTransform) > 0)
+
+	discovered := make(map[*Page]bool)
+	started := make(map[*Page]bool)
+	for _, ev := range evs {
+		switch ev.Kind {
+		case EventDiscovered:
+			discovered[ev.Page] = true
+
+		case EventRequestStart:
+			c.True(discovered[ev.Page], ev.Page.OrigURL.String())
+			started[ev.Page] = true
+
+		case EventRequestDone:
+			c.True(started[ev.Page], ev.Page.OrigURL.String())
+			c.Equal(ev.Status, http.StatusOK)
+			c.Nil(ev.Err)
+
+		case EventCleaned:
+			c.Equal(ev.Path, tmp.Path("/public/stale.txt"))
+		}
+	}
+
+	crawl()
+
+	c.Equal(count(EventWritten), 0)
+	c.Equal(count(EventUnchanged), 2)
+	c.Equal(count(EventCleaned), 0)
+}
+
+func TestEventsDiscoveredUnlocked(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	// Listeners may call back into the crawler, so the crawler can't be locked
+	// when they run
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/page">`,
+			},
+			"/page": stringHandler{
+				contType: htmlType,
+				body:     `page`,
+			},
+			fmt.Sprintf(errorPageURL, http.StatusNotFound): http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", htmlType)
+					w.WriteHeader(http.StatusNotFound)
+					io.WriteString(w, `not found`)
+				}),
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		ErrorPage(http.StatusNotFound, "/404.html"),
+		Events(func(ev Event) {
+			if ev.Kind == EventDiscovered {
+				ev.Page.cr.mtx.Lock()
+				ev.Page.cr.mtx.Unlock()
+			}
+		}))
+	c.Must.Nil(err)
+}
+
+type testLogger struct {
+	logs []string
+	errs []string
+}
+
+func (l *testLogger) Log(msg string) {
+	l.logs = append(l.logs, msg)
+}
+
+func (l *testLogger) Error(err error, msg string) {
+	l.errs = append(l.errs, msg+": "+err.Error())
+}
+
+func TestEventsLog(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	var log testLogger
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/page">`,
+			},
+			"/page": stringHandler{
+				contType: htmlType,
+				body:     `page`,
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Concurrency(1),
+		Events(LogEvents(&log)))
+	c.Must.Nil(err)
+
+	c.Must.Len(log.logs, 2)
+	c.True(strings.HasPrefix(log.logs[0], "[1/1] /: 200 in "), log.logs[0])
+	c.True(strings.HasPrefix(log.logs[1], "[2/2] /page: 200 in "), log.logs[1])
+	c.Len(log.errs, 0)
+}
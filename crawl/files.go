@@ -36,6 +36,33 @@ func parentDirs(file string) []string {
 // fileEquals determines if the contents of the regular file at path is the same
 // as the given bytes.
 func fileEquals(path string, b []byte) (equal bool, err error) {
+	return fileEqualsReader(path, int64(len(b)), bytes.NewReader(b))
+}
+
+// filesEqual determines if the contents of the regular file at path is the same
+// as the file at src. Neither file is read into memory.
+func filesEqual(path, src string) (equal bool, err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	return fileEqualsReader(path, info.Size(), f)
+}
+
+// fileEqualsReader determines if the contents of the regular file at path is
+// the same as the size bytes from r. The contents are compared a chunk at a
+// time, so nothing is read into memory.
+func fileEqualsReader(path string, size int64, r io.Reader) (
+	equal bool, err error) {
+
 	f, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -57,20 +84,65 @@ func fileEquals(path string, b []byte) (equal bool, err error) {
 		return
 	}
 
-	if info.Size() != int64(len(b)) {
+	if info.Size() != size {
 		return
 	}
 
-	fb := make([]byte, len(b))
-	_, err = io.ReadFull(f, fb)
-	if err != nil {
-		return
+	const chunk = 32 << 10
+	fb := make([]byte, chunk)
+	rb := make([]byte, chunk)
+
+	for size > 0 {
+		n := chunk
+		if size < chunk {
+			n = int(size)
+		}
+
+		_, err = io.ReadFull(f, fb[:n])
+		if err != nil {
+			return
+		}
+
+		_, err = io.ReadFull(r, rb[:n])
+		if err != nil {
+			return
+		}
+
+		if !bytes.Equal(fb[:n], rb[:n]) {
+			return
+		}
+
+		size -= int64(n)
 	}
 
-	equal = bytes.Equal(fb, b)
+	equal = true
 	return
 }
 
+// fileCopy copies the file at src to path
+func fileCopy(src, path string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
 func filePrepWrite(path string) error {
 	// FIXME(as): if a path like "/a/b" already exists, then trying to create
 	// file at "/a/b/c" will fail since b is a file, not a dir.
@@ -1,6 +1,7 @@
 package crawl
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/thatguystone/acrylic/internal/testutil"
@@ -65,3 +66,39 @@ func TestFileEquals(t *testing.T) {
 	c.Nil(err)
 	c.False(equal)
 }
+
+func TestFilesEqual(t *testing.T) {
+	c := check.New(t)
+
+	big := strings.Repeat("abc", 50000)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"0.txt":   "abc",
+		"1.txt":   "abc",
+		"2.txt":   "abd",
+		"big0":    big,
+		"big1":    big,
+		"big2":    big[:len(big)-1] + "d",
+		"dir/sub": "",
+	})
+	defer tmp.Remove()
+
+	tests := []struct {
+		a, b  string
+		equal bool
+	}{
+		{a: "0.txt", b: "1.txt", equal: true},
+		{a: "0.txt", b: "2.txt", equal: false},
+		{a: "big0", b: "big1", equal: true},
+		{a: "big0", b: "big2", equal: false},
+		{a: "0.txt", b: "big0", equal: false},
+		{a: "dir", b: "0.txt", equal: false},
+		{a: "does not exist", b: "0.txt", equal: false},
+	}
+
+	for _, test := range tests {
+		equal, err := filesEqual(tmp.Path(test.a), tmp.Path(test.b))
+		c.Nil(err, test.a, test.b)
+		c.Equal(equal, test.equal, test.a, test.b)
+	}
+}
@@ -0,0 +1,101 @@
+package crawl
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A URLMatcher decides if a URL is matched by an Include or Exclude. URLs are
+// always site-relative (eg. "/drafts/post?draft=1"): external URLs are never
+// crawled, so they're never matched.
+type URLMatcher interface {
+	Match(u *url.URL) bool
+}
+
+// MatchFunc is a URLMatcher that calls itself
+type MatchFunc func(u *url.URL) bool
+
+// Match implements URLMatcher
+func (fn MatchFunc) Match(u *url.URL) bool {
+	return fn(u)
+}
+
+// Glob matches URL paths against the given pattern. A "*" matches anything but
+// "/", "**" matches anything (including nothing), and "?" matches any single
+// character but "/". Everything else is matched literally.
+//
+// For example, "/drafts/**" matches everything under /drafts/, and "/*.php"
+// matches any .php file at the root.
+func Glob(pattern string) URLMatcher {
+	re := regexp.MustCompile(globToRegexp(pattern))
+	return MatchFunc(func(u *url.URL) bool {
+		return re.MatchString(u.Path)
+	})
+}
+
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			b.WriteString(".*")
+			i++
+
+		case c == '*':
+			b.WriteString("[^/]*")
+
+		case c == '?':
+			b.WriteString("[^/]")
+
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return b.String()
+}
+
+// Regexp matches URLs against re. The path and query are matched together
+// (eg. "/search?q=test"), so this can also match URLs by query.
+func Regexp(re *regexp.Regexp) URLMatcher {
+	return MatchFunc(func(u *url.URL) bool {
+		uu := *u
+		uu.Fragment = ""
+		return re.MatchString(uu.String())
+	})
+}
+
+type urlFilter struct {
+	include   []URLMatcher
+	exclude   []URLMatcher
+	useRobots bool
+	robots    robotsRules
+	warn      bool
+}
+
+// allowed determines if a link to u should be crawled
+func (f *urlFilter) allowed(u *url.URL) bool {
+	if len(f.include) > 0 && !anyMatch(f.include, u) {
+		return false
+	}
+
+	if anyMatch(f.exclude, u) {
+		return false
+	}
+
+	return f.robots.allowed(u)
+}
+
+func anyMatch(ms []URLMatcher, u *url.URL) bool {
+	for _, m := range ms {
+		if m.Match(u) {
+			return true
+		}
+	}
+
+	return false
+}
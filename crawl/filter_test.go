@@ -0,0 +1,211 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestFilterMatchers(t *testing.T) {
+	c := check.New(t)
+
+	tests := []struct {
+		m     URLMatcher
+		url   string
+		match bool
+	}{
+		{Glob("/drafts/**"), "/drafts/", true},
+		{Glob("/drafts/**"), "/drafts/a/b/c", true},
+		{Glob("/drafts/**"), "/drafts", false},
+		{Glob("/drafts/*"), "/drafts/a", true},
+		{Glob("/drafts/*"), "/drafts/a/b", false},
+		{Glob("/*.php"), "/index.php", true},
+		{Glob("/*.php"), "/index.phps", false},
+		{Glob("/?.txt"), "/a.txt", true},
+		{Glob("/?.txt"), "/ab.txt", false},
+		{Glob("/a+b"), "/a+b", true},
+		{Glob("/a+b"), "/aab", false},
+		{Glob("/**"), "/search?q=1", true},
+		{Regexp(regexp.MustCompile(`\?`)), "/search?q=1", true},
+		{Regexp(regexp.MustCompile(`\?`)), "/search", false},
+		{Regexp(regexp.MustCompile(`^/admin`)), "/admin/users", true},
+		{Regexp(regexp.MustCompile(`frag`)), "/page#frag", false},
+		{
+			MatchFunc(func(u *url.URL) bool {
+				return u.Query().Get("preview") != ""
+			}),
+			"/post?preview=1",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		c.Must.Nil(err)
+
+		c.Equal(test.m.Match(u), test.match, test.url)
+	}
+}
+
+func TestFilterCrawl(t *testing.T) {
+	c := check.New(t)
+
+	const index = `` +
+		`<a href="/admin/">` +
+		`<a href="/drafts/post">` +
+		`<a href="drafts/relative">` +
+		`<a href="/blog/post">` +
+		`<a href="/blog/post?preview=1">` +
+		`<a href="/about">` +
+		`<a href="https://example.com/admin/">`
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		crawled  []string
+		excluded []string
+	}{
+		{
+			name: "Exclude",
+			opts: []Option{
+				Exclude(
+					Glob("/admin/**"),
+					Glob("/drafts/**"),
+					Regexp(regexp.MustCompile(`\?`))),
+			},
+			crawled: []string{"/blog/post", "/about"},
+			excluded: []string{
+				"/admin/",
+				"/drafts/post",
+				"drafts/relative",
+				"/blog/post?preview=1",
+			},
+		},
+		{
+			name: "Include",
+			opts: []Option{
+				Include(Glob("/blog/**")),
+			},
+			crawled: []string{"/blog/post", "/blog/post?preview=1"},
+			excluded: []string{
+				"/admin/",
+				"/drafts/post",
+				"drafts/relative",
+				"/about",
+			},
+		},
+		{
+			name: "IncludeAndExclude",
+			opts: []Option{
+				Include(Glob("/blog/**")),
+				Exclude(MatchFunc(func(u *url.URL) bool {
+					return u.Query().Get("preview") != ""
+				})),
+			},
+			crawled: []string{"/blog/post"},
+			excluded: []string{
+				"/admin/",
+				"/drafts/post",
+				"drafts/relative",
+				"/blog/post?preview=1",
+				"/about",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		c.Run(test.name, func(c *check.C) {
+			tmp := testutil.NewTmpDir(c, nil)
+			defer tmp.Remove()
+
+			page := stringHandler{
+				contType: htmlType,
+				body:     `page`,
+			}
+
+			opts := append([]Option{
+				Output(tmp.Path("/public")),
+				FingerprintCache(""),
+				WarnExcluded(),
+			}, test.opts...)
+
+			site, err := Crawl(
+				mux(map[string]http.Handler{
+					"/": stringHandler{
+						contType: htmlType,
+						body:     index,
+					},
+					"/blog/":  page,
+					"/about":  page,
+					"/admin/": page,
+				}),
+				opts...)
+			c.Must.Nil(err)
+			tmp.DumpTree()
+
+			get := func(link string) *Page {
+				u, err := url.Parse(link)
+				c.Must.Nil(err)
+
+				return site.Get(u)
+			}
+
+			for _, u := range test.crawled {
+				c.NotNil(get(u), u)
+			}
+
+			html := tmp.ReadFile("/public/index.html")
+			c.Contains(html, "https://example.com/admin/")
+
+			var warnings []error
+			for _, u := range test.excluded {
+				c.True(get("/"+strings.TrimPrefix(u, "/")) == nil, u)
+				c.Contains(html, u)
+				warnings = append(warnings, ExcludedLinkError(u))
+			}
+
+			c.Equal(site.Warnings()["/"], warnings)
+		})
+	}
+}
+
+func TestFilterRedirect(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/old">`,
+			},
+			"/old": http.RedirectHandler("/admin/", http.StatusFound),
+			"/admin/": http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					c.Errorf("excluded redirect target was crawled")
+				}),
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		Exclude(Glob("/admin/**")),
+		WarnExcluded())
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	c.True(site.Get(&url.URL{Path: "/admin/"}) == nil)
+	c.Equal(
+		site.Get(&url.URL{Path: "/old"}).FollowRedirects().URL.String(),
+		"/admin/")
+	c.Contains(tmp.ReadFile("/public/index.html"), `href=/admin/`)
+	c.Equal(
+		site.Warnings()["/old"],
+		[]error{ExcludedLinkError("/admin/")})
+}
@@ -2,30 +2,70 @@ package crawl
 
 import (
 	"compress/gzip"
-	"crypto/sha1"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/thatguystone/cog/cfs"
 )
 
+// A FingerprintPlace is where a fingerprint goes in a URL
+type FingerprintPlace int
+
+const (
+	// FingerprintName puts the fingerprint in the file name, before the
+	// extension: app.css becomes app.<hash>.css
+	FingerprintName FingerprintPlace = iota
+
+	// FingerprintQuery leaves the file name alone and adds the fingerprint to
+	// the query string as a cache buster: app.css becomes app.css?v=<hash>.
+	// The file is served from the same path with every change, so this only
+	// works with hosts that don't ignore the query when caching.
+	FingerprintQuery
+)
+
+// fingerprintQueryKey is the query parameter used with FingerprintQuery
+const fingerprintQueryKey = "v"
+
 type fingerprints struct {
 	cb        func(u *url.URL, mediaType string) bool
+	newHash   func() hash.Hash
+	length    int // Number of hex digits to keep; 0 for all
+	place     FingerprintPlace
 	cacheFile string
 	rwmtx     sync.RWMutex
-	cache     map[string]fingerprintEntry
+	cache     map[string]fingerprintEntry // Absolute path or URL -> entry
+	seen      map[string]struct{}         // URL keys used in this crawl
+}
+
+const (
+	// fingerprintCacheVersion is bumped any time the format of the cache
+	// changes
+	fingerprintCacheVersion = 3
+
+	// Cache keys for generated bodies are URLs with this prefix so that they
+	// can't be confused with the absolute paths used for files
+	fingerprintURLPrefix = "url:"
+)
+
+type fingerprintCache struct {
+	Version int
+	Hash    string // From hashID
+	Entries map[string]fingerprintEntry
 }
 
 type fingerprintEntry struct {
-	H string
-	T time.Time
+	H string    // Full digest, before it's cut down to length
+	T time.Time `json:",omitempty"` // Mod time of a file
+	S string    `json:",omitempty"` // Signature of a generated body
 }
 
 func (fps *fingerprints) cacheEnabled() bool {
@@ -42,6 +82,7 @@ func (fps *fingerprints) loadCache() {
 	// In case any of the following fails, start out with a blank, writable
 	// cache
 	fps.cache = make(map[string]fingerprintEntry)
+	fps.seen = make(map[string]struct{})
 
 	// Lock while loading, just in case anyone tries to read before the load has
 	// finished
@@ -61,19 +102,45 @@ func (fps *fingerprints) loadCache() {
 			return
 		}
 
-		json.NewDecoder(gz).Decode(&fps.cache)
+		// Anything from an older version or a different hash would give the
+		// wrong fingerprints, so it's thrown away
+		var cache fingerprintCache
+		err = json.NewDecoder(gz).Decode(&cache)
+		if err != nil ||
+			cache.Version != fingerprintCacheVersion ||
+			cache.Hash != fps.hashID() ||
+			cache.Entries == nil {
+
+			return
+		}
+
+		fps.cache = cache.Entries
 	}()
 }
 
-func (fps *fingerprints) saveCache(used usedFiles) error {
+func (fps *fingerprints) saveCache(used usedFiles, w *outputWriter) error {
 	if !fps.cacheEnabled() {
 		return nil
 	}
 
-	for path := range fps.cache {
-		if _, ok := used[path]; !ok {
-			delete(fps.cache, path)
+	for key := range fps.cache {
+		if strings.HasPrefix(key, fingerprintURLPrefix) {
+			if _, ok := fps.seen[key]; !ok {
+				w.removeFingerprint(key)
+				delete(fps.cache, key)
+			}
+
+			continue
 		}
+
+		if _, ok := used[key]; !ok {
+			w.removeFingerprint(key)
+			delete(fps.cache, key)
+		}
+	}
+
+	if w.dryRun {
+		return nil
 	}
 
 	err := filePrepWrite(fps.cacheFile)
@@ -94,7 +161,11 @@ func (fps *fingerprints) saveCache(used usedFiles) error {
 
 	gz := gzip.NewWriter(f)
 
-	err = json.NewEncoder(gz).Encode(fps.cache)
+	err = json.NewEncoder(gz).Encode(fingerprintCache{
+		Version: fingerprintCacheVersion,
+		Hash:    fps.hashID(),
+		Entries: fps.cache,
+	})
 	if err != nil {
 		return err
 	}
@@ -115,11 +186,44 @@ func (fps *fingerprints) should(u *url.URL, mediaType string) bool {
 	return fps.cb(u, mediaType)
 }
 
-func (fps *fingerprints) get(resp *response) (string, error) {
-	if !fps.cacheEnabled() || !resp.body.canSymlink() {
+// hashID identifies the hash function in use so that a cache created with a
+// different one isn't used. There's no way to get a name from a hash.Hash, so
+// this is the digest of a fixed string instead.
+func (fps *fingerprints) hashID() string {
+	h := fps.newHash()
+	io.WriteString(h, "acrylic")
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (fps *fingerprints) get(u *url.URL, resp *response) (string, error) {
+	fp, err := fps.getFull(u, resp)
+	if err != nil {
+		return "", err
+	}
+
+	if fps.length > 0 && fps.length < len(fp) {
+		fp = fp[:fps.length]
+	}
+
+	return fp, nil
+}
+
+func (fps *fingerprints) getFull(u *url.URL, resp *response) (string, error) {
+	switch {
+	case !fps.cacheEnabled():
 		return fps.calc(resp)
+
+	case resp.body.canSymlink():
+		return fps.getFile(resp)
+
+	default:
+		return fps.getGenerated(u, resp)
 	}
+}
 
+// getFile gets the fingerprint of a file from ServeFile, using the cache as
+// long as the file hasn't been modified
+func (fps *fingerprints) getFile(resp *response) (string, error) {
 	absSrc := absPath(resp.body.symSrc)
 
 	srcInfo, err := os.Stat(resp.body.symSrc)
@@ -150,7 +254,45 @@ func (fps *fingerprints) get(resp *response) (string, error) {
 	return fe.H, nil
 }
 
+// getGenerated gets the fingerprint of an in-memory body. These are cached by
+// URL, using the body's signature to check that the cached fingerprint is still
+// good.
+func (fps *fingerprints) getGenerated(u *url.URL, resp *response) (
+	string, error) {
+
+	key := fingerprintURLPrefix + u.String()
+	sig := resp.signature()
+
+	fps.rwmtx.Lock()
+	fps.seen[key] = struct{}{}
+	fe, ok := fps.cache[key]
+	fps.rwmtx.Unlock()
+
+	if !ok || fe.S != sig {
+		h, err := fps.calc(resp)
+		if err != nil {
+			return "", err
+		}
+
+		fe = fingerprintEntry{
+			H: h,
+			S: sig,
+		}
+
+		fps.rwmtx.Lock()
+		fps.cache[key] = fe
+		fps.rwmtx.Unlock()
+	}
+
+	return fe.H, nil
+}
+
 func (fps *fingerprints) calc(resp *response) (string, error) {
+	// Spilled bodies were hashed as they were written
+	if sp := resp.body.spill; sp != nil {
+		return sp.sum, nil
+	}
+
 	r, err := resp.body.reader()
 	if err != nil {
 		return "", err
@@ -158,11 +300,24 @@ func (fps *fingerprints) calc(resp *response) (string, error) {
 
 	defer r.Close()
 
-	return fingerprint(r)
+	return fingerprint(fps.newHash, r)
+}
+
+// addTo adds the given fingerprint to u
+func (fps *fingerprints) addTo(u *url.URL, fp string) {
+	switch fps.place {
+	case FingerprintQuery:
+		q := u.Query()
+		q.Set(fingerprintQueryKey, fp)
+		u.RawQuery = q.Encode()
+
+	default:
+		u.Path = addFingerprint(outputURLPath(u.Path), fp)
+	}
 }
 
-func fingerprint(r io.Reader) (string, error) {
-	hash := sha1.New()
+func fingerprint(newHash func() hash.Hash, r io.Reader) (string, error) {
+	hash := newHash()
 
 	_, err := io.Copy(hash, r)
 	if err != nil {
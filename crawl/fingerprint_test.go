@@ -1,16 +1,23 @@
 package crawl
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"testing"
 
+	"github.com/thatguystone/acrylic/internal/testutil"
 	"github.com/thatguystone/cog/check"
 )
 
 func TestFingerprintBasic(t *testing.T) {
 	c := check.New(t)
 
-	fp, err := fingerprint(strings.NewReader("test"))
+	fp, err := fingerprint(sha1.New, strings.NewReader("test"))
 	c.Nil(err)
 	c.Equal(fp, "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
 }
@@ -26,3 +33,291 @@ func TestFingerprintAdd(t *testing.T) {
 		"test.tar.abcd1234.gz", // Unfortunate :(
 		addFingerprint("test.tar.gz", "abcd1234"))
 }
+
+func TestFingerprintOptions(t *testing.T) {
+	c := check.New(t)
+
+	sha1Full, err := fingerprint(sha1.New, bytes.NewReader(testutil.GifBin))
+	c.Must.Nil(err)
+
+	sha256Full, err := fingerprint(sha256.New, bytes.NewReader(testutil.GifBin))
+	c.Must.Nil(err)
+
+	tests := []struct {
+		name string
+		opts []Option
+		html string
+		path string
+		fp   string
+	}{
+		{
+			name: "Default",
+			html: `<img src="/img.gif">`,
+			path: "/img." + sha1Full + ".gif",
+			fp:   sha1Full,
+		},
+		{
+			name: "Hash",
+			opts: []Option{FingerprintHash(sha256.New, 0)},
+			html: `<img src="/img.gif">`,
+			path: "/img." + sha256Full + ".gif",
+			fp:   sha256Full,
+		},
+		{
+			name: "Length",
+			opts: []Option{FingerprintHash(sha256.New, 8)},
+			html: `<img src="/img.gif">`,
+			path: "/img." + sha256Full[:8] + ".gif",
+			fp:   sha256Full[:8],
+		},
+		{
+			name: "LengthTooLong",
+			opts: []Option{FingerprintHash(sha1.New, 1000)},
+			html: `<img src="/img.gif">`,
+			path: "/img." + sha1Full + ".gif",
+			fp:   sha1Full,
+		},
+		{
+			name: "Query",
+			opts: []Option{
+				FingerprintHash(sha1.New, 10),
+				FingerprintPlacement(FingerprintQuery),
+			},
+			html: `<img src="/img.gif?a=b">`,
+			path: "/img.gif?a=b&v=" + sha1Full[:10],
+			fp:   sha1Full[:10],
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		c.Run(test.name, func(c *check.C) {
+			tmp := testutil.NewTmpDir(c, nil)
+			defer tmp.Remove()
+
+			opts := append([]Option{
+				Output(tmp.Path("/public")),
+				FingerprintCache(tmp.Path(".cache/fingerprints")),
+				Fingerprint(func(u *url.URL, mediaType string) bool {
+					return mediaType == testutil.GifType
+				}),
+			}, test.opts...)
+
+			site, err := Crawl(
+				mux(map[string]http.Handler{
+					"/": stringHandler{
+						contType: htmlType,
+						body:     test.html,
+					},
+					"/img.gif": stringHandler{
+						contType: testutil.GifType,
+						body:     string(testutil.GifBin),
+					},
+				}),
+				opts...)
+			c.Must.Nil(err)
+			tmp.DumpTree()
+
+			index := tmp.ReadFile("/public/index.html")
+			c.Contains(index, strings.Replace(test.path, "&", "&amp;", -1))
+
+			u, err := url.Parse(test.path)
+			c.Must.Nil(err)
+
+			c.Equal(tmp.ReadFile("/public"+u.Path), string(testutil.GifBin))
+
+			pg := site.GetPage("/img.gif")
+			c.Must.NotNil(pg)
+			c.Equal(pg.Fingerprint, test.fp)
+			c.Equal(pg.URL.String(), test.path)
+		})
+	}
+}
+
+func TestFingerprintCacheHashChange(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/img.gif": string(testutil.GifBin),
+	})
+	defer tmp.Remove()
+
+	crawl := func(opts ...Option) *Page {
+		site, err := Crawl(
+			mux(map[string]http.Handler{
+				"/": stringHandler{
+					contType: htmlType,
+					body:     `<img src="/img.gif">`,
+				},
+				"/img.gif": http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						ServeFile(w, r, tmp.Path("/img.gif"))
+					}),
+			}),
+			append([]Option{
+				Output(tmp.Path("/public")),
+				FingerprintCache(tmp.Path(".cache/fingerprints")),
+				Fingerprint(func(u *url.URL, mediaType string) bool {
+					return mediaType == testutil.GifType
+				}),
+			}, opts...)...)
+		c.Must.Nil(err)
+
+		return site.GetPage("/img.gif")
+	}
+
+	sha1Full, err := fingerprint(sha1.New, bytes.NewReader(testutil.GifBin))
+	c.Must.Nil(err)
+
+	sha256Full, err := fingerprint(sha256.New, bytes.NewReader(testutil.GifBin))
+	c.Must.Nil(err)
+
+	pg := crawl()
+	c.Equal(pg.Fingerprint, sha1Full)
+
+	// With the file unchanged, the cached SHA-1 would be used if the cache
+	// weren't tied to the hash
+	pg = crawl(FingerprintHash(sha256.New, 8))
+	c.Equal(pg.Fingerprint, sha256Full[:8])
+}
+
+func TestFingerprintCacheGenerated(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	newFPs := func() *fingerprints {
+		fps := &fingerprints{
+			newHash:   sha1.New,
+			cacheFile: tmp.Path(".cache/fingerprints"),
+		}
+
+		fps.loadCache()
+		return fps
+	}
+
+	newResp := func(body string, header http.Header, transformed bool) *response {
+		resp := &response{
+			header: header,
+			body:   responseBody{b: []byte(body)},
+		}
+
+		if transformed {
+			resp.body.set(resp.body.b)
+		}
+
+		return resp
+	}
+
+	u := &url.URL{Path: "/app.css"}
+	etag := http.Header{"Etag": {`"1"`}}
+
+	tests := []struct {
+		name    string
+		cached  *response
+		resp    *response
+		useHash bool
+	}{
+		{
+			name:   "SameBody",
+			cached: newResp("body", nil, false),
+			resp:   newResp("body", nil, false),
+		},
+		{
+			name:    "ChangedBody",
+			cached:  newResp("body", nil, false),
+			resp:    newResp("BODY", nil, false),
+			useHash: true,
+		},
+		{
+			name:   "SameETag",
+			cached: newResp("body", etag, false),
+			resp:   newResp("BODY", etag, false),
+		},
+		{
+			name:    "ETagTransformed",
+			cached:  newResp("body", etag, true),
+			resp:    newResp("BODY", etag, true),
+			useHash: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		c.Run(test.name, func(c *check.C) {
+			fps := newFPs()
+
+			_, err := fps.get(u, test.cached)
+			c.Must.Nil(err)
+
+			// Swap in a fake fingerprint to see if the cache is used
+			key := fingerprintURLPrefix + u.String()
+			fe := fps.cache[key]
+			fe.H = "cached"
+			fps.cache[key] = fe
+
+			err = fps.saveCache(usedFiles{}, &outputWriter{})
+			c.Must.Nil(err)
+
+			fps = newFPs()
+
+			fp, err := fps.get(u, test.resp)
+			c.Must.Nil(err)
+
+			if test.useHash {
+				expect, err := fps.calc(test.resp)
+				c.Must.Nil(err)
+				c.Equal(fp, expect)
+			} else {
+				c.Equal(fp, "cached")
+			}
+		})
+	}
+}
+
+func TestFingerprintCachePrune(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/file": `file`,
+	})
+	defer tmp.Remove()
+
+	fps := fingerprints{
+		newHash:   sha1.New,
+		cacheFile: tmp.Path(".cache/fingerprints"),
+	}
+	fps.loadCache()
+
+	resp := &response{body: responseBody{b: []byte("body")}}
+	_, err := fps.get(&url.URL{Path: "/seen"}, resp)
+	c.Must.Nil(err)
+
+	fileResp := &response{body: responseBody{symSrc: tmp.Path("/file")}}
+	_, err = fps.get(nil, fileResp)
+	c.Must.Nil(err)
+
+	fps.cache[fingerprintURLPrefix+"/unseen"] = fingerprintEntry{H: "h"}
+	fps.cache[tmp.Path("/unused")] = fingerprintEntry{H: "h"}
+
+	w := &outputWriter{dryRun: true}
+	err = fps.saveCache(usedFiles{tmp.Path("/file"): {}}, w)
+	c.Must.Nil(err)
+
+	c.Equal(w.getReport().FingerprintsRemoved, []string{
+		tmp.Path("/unused"),
+		fingerprintURLPrefix + "/unseen",
+	})
+
+	var keys []string
+	for key := range fps.cache {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	c.Equal(keys, []string{
+		tmp.Path("/file"),
+		fingerprintURLPrefix + "/seen",
+	})
+}
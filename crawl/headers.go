@@ -0,0 +1,376 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A HeaderMode determines how response headers are written out
+type HeaderMode int
+
+const (
+	// HeadersNetlify writes a _headers file to the root of the output
+	// directory, as used by Netlify and Cloudflare Pages
+	HeadersNetlify HeaderMode = iota + 1
+
+	// HeadersNginx writes an nginx include to the root of the output
+	// directory with a location block for every rule. Include it in the
+	// server block that serves the output.
+	//
+	// nginx only inherits add_header directives from the server block into
+	// locations that don't have any of their own, so any add_header in the
+	// server block no longer applies to URLs matched by these locations.
+	// Have the handler send those headers too, so that they're exported
+	// with the rest.
+	HeadersNginx
+
+	// HeadersApache writes an .htaccess to the root of the output directory.
+	// It needs Apache 2.4 with mod_headers.
+	HeadersApache
+)
+
+const (
+	netlifyHeadersPath = "/_headers"
+	nginxHeadersPath   = "/headers.nginx.conf"
+	apacheHeadersPath  = "/.htaccess"
+)
+
+// exportHeaders picks out the headers that are written out by Headers
+func (cr *crawler) exportHeaders(h http.Header) http.Header {
+	var picked http.Header
+	for _, name := range cr.headers {
+		if vs, ok := h[name]; ok {
+			if picked == nil {
+				picked = make(http.Header)
+			}
+
+			picked[name] = vs
+		}
+	}
+
+	return picked
+}
+
+// A headerRule sets headers for a URL, or everything under a directory
+type headerRule struct {
+	path   string // Escaped URL path
+	dir    bool   // If path is a directory, and the rule covers all of it
+	header http.Header
+}
+
+// decodedPath gets the rule's path as nginx and Apache see it: they match
+// against the decoded URL path.
+func (rule headerRule) decodedPath() string {
+	path, err := url.PathUnescape(rule.path)
+	if err != nil {
+		return rule.path // Can't happen: path came from url.EscapedPath
+	}
+
+	return path
+}
+
+func genHeaders(cr *crawler, mode HeaderMode, names []string) {
+	root := newHeaderNode()
+	for _, pg := range cr.site.urls {
+		// Only pages the handler served have headers: generated files (and
+		// redirect stubs) would otherwise keep directories from collapsing
+		if !pg.served || pg.IsExternal() || pg.AliasOf != nil {
+			continue
+		}
+
+		h := make(http.Header)
+		for _, name := range names {
+			name = http.CanonicalHeaderKey(name)
+			if vs, ok := pg.headers[name]; ok {
+				h[name] = vs
+			}
+		}
+
+		root.add(pg.URL.EscapedPath(), h)
+	}
+
+	rules := root.rules("/", true)
+
+	switch mode {
+	case HeadersNetlify:
+		genFile(cr, netlifyHeadersPath, netlifyHeaders(rules))
+
+	case HeadersNginx:
+		genFile(cr, nginxHeadersPath, nginxHeaders(rules))
+
+	case HeadersApache:
+		genFile(cr, apacheHeadersPath, apacheHeaders(rules))
+
+	default:
+		cr.addError(url.URL{}, fmt.Errorf("unknown header mode %d", mode))
+	}
+}
+
+// A headerNode is a single path segment in a tree of every page's URL, used to
+// find directories that can be collapsed into a single rule
+type headerNode struct {
+	children map[string]*headerNode // Dirs have a trailing "/"
+	header   http.Header            // If there's a page here
+	isPage   bool
+}
+
+func newHeaderNode() *headerNode {
+	return &headerNode{
+		children: make(map[string]*headerNode),
+	}
+}
+
+func (n *headerNode) add(urlPath string, h http.Header) {
+	segs := strings.Split(strings.TrimPrefix(urlPath, "/"), "/")
+	for i, seg := range segs {
+		if i < len(segs)-1 {
+			seg += "/"
+		}
+
+		child, ok := n.children[seg]
+		if !ok {
+			child = newHeaderNode()
+			n.children[seg] = child
+		}
+
+		n = child
+	}
+
+	n.isPage = true
+	n.header = h
+}
+
+// uniform gets the header signature shared by every page under n, if they all
+// share one. Also returns the number of pages.
+func (n *headerNode) uniform() (sig string, pages int, ok bool) {
+	ok = true
+	first := true
+
+	check := func(s string) {
+		if first {
+			sig = s
+			first = false
+		} else if s != sig {
+			ok = false
+		}
+	}
+
+	if n.isPage {
+		check(headerSig(n.header))
+		pages++
+	}
+
+	for _, child := range n.children {
+		s, count, childOK := child.uniform()
+		if count == 0 {
+			continue
+		}
+
+		ok = ok && childOK
+		check(s)
+		pages += count
+	}
+
+	return
+}
+
+func (n *headerNode) rules(prefix string, isDir bool) []headerRule {
+	if isDir {
+		sig, pages, ok := n.uniform()
+		if ok && pages > 1 {
+			if sig == "" {
+				return nil
+			}
+
+			return []headerRule{{
+				path:   prefix,
+				dir:    true,
+				header: n.anyHeader(),
+			}}
+		}
+	}
+
+	var rules []headerRule
+	if n.isPage && len(n.header) > 0 {
+		rules = append(rules, headerRule{
+			path:   prefix,
+			header: n.header,
+		})
+	}
+
+	var segs []string
+	for seg := range n.children {
+		segs = append(segs, seg)
+	}
+
+	sort.Strings(segs)
+
+	for _, seg := range segs {
+		child := n.children[seg]
+		rules = append(rules,
+			child.rules(prefix+seg, strings.HasSuffix(seg, "/"))...)
+	}
+
+	return rules
+}
+
+// anyHeader gets the header of any page under n
+func (n *headerNode) anyHeader() http.Header {
+	if n.isPage {
+		return n.header
+	}
+
+	for _, child := range n.children {
+		if h := child.anyHeader(); h != nil {
+			return h
+		}
+	}
+
+	return nil
+}
+
+// headerSig gets a string that's the same for equal headers
+func headerSig(h http.Header) string {
+	var b strings.Builder
+	for _, name := range sortedHeaderNames(h) {
+		for _, v := range h[name] {
+			fmt.Fprintf(&b, "%s: %s\n", name, v)
+		}
+	}
+
+	return b.String()
+}
+
+func sortedHeaderNames(h http.Header) []string {
+	var names []string
+	for name := range h {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func netlifyHeaders(rules []headerRule) []byte {
+	var b bytes.Buffer
+	for _, rule := range rules {
+		path := rule.path
+		if rule.dir {
+			path += "*"
+		}
+
+		fmt.Fprintf(&b, "%s\n", path)
+
+		for _, name := range sortedHeaderNames(rule.header) {
+			for _, v := range rule.header[name] {
+				fmt.Fprintf(&b, "  %s: %s\n", name, v)
+			}
+		}
+	}
+
+	return b.Bytes()
+}
+
+func nginxHeaders(rules []headerRule) []byte {
+	var b bytes.Buffer
+	for _, rule := range rules {
+		match := "="
+		if rule.dir {
+			match = "^~"
+		}
+
+		fmt.Fprintf(&b, "location %s %s {\n",
+			match, nginxQuote(rule.decodedPath()))
+
+		for _, name := range sortedHeaderNames(rule.header) {
+			for _, v := range rule.header[name] {
+				fmt.Fprintf(&b, "\tadd_header %s %s always;\n",
+					name, nginxQuote(v))
+			}
+		}
+
+		b.WriteString("}\n")
+	}
+
+	return b.Bytes()
+}
+
+// nginxQuote quotes s as an nginx string. nginx only understands escaped
+// quotes and backslashes: everything else is taken byte for byte.
+func nginxQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
+
+func apacheHeaders(rules []headerRule) []byte {
+	var b bytes.Buffer
+	b.WriteString("<IfModule mod_headers.c>\n")
+
+	for _, rule := range rules {
+		cond := "%{REQUEST_URI} == " + apacheExprString(rule.decodedPath())
+		if rule.dir {
+			cond = "%{REQUEST_URI} =~ " + apacheExprPrefix(rule.decodedPath())
+		}
+
+		fmt.Fprintf(&b, "<If %s>\n", apacheQuote(cond))
+
+		for _, name := range sortedHeaderNames(rule.header) {
+			for i, v := range rule.header[name] {
+				action := "set"
+				if i > 0 {
+					action = "add"
+				}
+
+				// Header values are format strings
+				v = strings.Replace(v, "%", "%%", -1)
+
+				fmt.Fprintf(&b, "\tHeader %s %s %s\n",
+					action, name, apacheQuote(v))
+			}
+		}
+
+		b.WriteString("</If>\n")
+	}
+
+	b.WriteString("</IfModule>\n")
+	return b.Bytes()
+}
+
+// apacheQuote quotes s as a single Apache config argument. Only quotes are
+// escaped: Apache leaves every other backslash alone.
+func apacheQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// apacheExprString quotes s as a string in an Apache expression, where
+// variables (%{...}) and regex backreferences ($1) are expanded
+func apacheExprString(s string) string {
+	var b strings.Builder
+	b.WriteByte('\'')
+
+	for _, r := range s {
+		switch r {
+		case '\\', '\'', '%', '$':
+			b.WriteByte('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// apacheExprPrefix creates an Apache expression regex that matches everything
+// starting with s
+func apacheExprPrefix(s string) string {
+	// The regex is delimited by "#", so it can't appear literally
+	re := strings.Replace(regexp.QuoteMeta(s), "#", `\x23`, -1)
+	return "m#^" + re + "#"
+}
@@ -0,0 +1,215 @@
+package crawl
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+type headerHandler struct {
+	header http.Header
+	stringHandler
+}
+
+func (h headerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for k, vs := range h.header {
+		w.Header()[k] = vs
+	}
+
+	h.stringHandler.ServeHTTP(w, r)
+}
+
+func TestHeaders(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	css := headerHandler{
+		header: http.Header{
+			"Cache-Control": {"public, max-age=31536000, immutable"},
+			"X-Ignored":     {"nope"},
+		},
+		stringHandler: stringHandler{
+			contType: "text/css",
+			body:     `a{color:red}`,
+		},
+	}
+
+	handler := mux(map[string]http.Handler{
+		"/": headerHandler{
+			header: http.Header{
+				"X-Frame-Options": {"DENY"},
+			},
+			stringHandler: stringHandler{
+				contType: htmlType,
+				body: `` +
+					`<link rel="stylesheet" href="/css/a.css">` +
+					`<link rel="stylesheet" href="/css/b.css">` +
+					`<a href="/about">` +
+					`<a href="/plain">` +
+					`<a href="/css/old.css">`,
+			},
+		},
+		"/about": headerHandler{
+			header: http.Header{
+				"X-Frame-Options": {"DENY"},
+				"Link": {
+					`</a>; rel="a"`,
+					`</b>; rel="b"`,
+				},
+			},
+			stringHandler: stringHandler{
+				contType: htmlType,
+				body:     `about`,
+			},
+		},
+		"/plain": stringHandler{
+			contType: htmlType,
+			body:     `plain`,
+		},
+		"/css/a.css": css,
+		"/css/b.css": css,
+		"/css/old.css": http.RedirectHandler(
+			"/css/a.css", http.StatusMovedPermanently),
+	})
+
+	// The redirect stub must not keep /css/ from collapsing
+	_, err := Crawl(handler,
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		Redirects(RedirectHTML),
+		Headers(HeadersNetlify, "cache-control", "x-frame-options", "link"),
+		Headers(HeadersNginx, "Cache-Control", "X-Frame-Options", "Link"),
+		Headers(HeadersApache, "Cache-Control", "X-Frame-Options", "Link"))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	c.Equal(
+		tmp.ReadFile("/public/_headers"),
+		""+
+			"/\n"+
+			"  X-Frame-Options: DENY\n"+
+			"/about\n"+
+			"  Link: </a>; rel=\"a\"\n"+
+			"  Link: </b>; rel=\"b\"\n"+
+			"  X-Frame-Options: DENY\n"+
+			"/css/*\n"+
+			"  Cache-Control: public, max-age=31536000, immutable\n")
+
+	c.Equal(
+		tmp.ReadFile("/public/headers.nginx.conf"),
+		""+
+			"location = \"/\" {\n"+
+			"\tadd_header X-Frame-Options \"DENY\" always;\n"+
+			"}\n"+
+			"location = \"/about\" {\n"+
+			"\tadd_header Link \"</a>; rel=\\\"a\\\"\" always;\n"+
+			"\tadd_header Link \"</b>; rel=\\\"b\\\"\" always;\n"+
+			"\tadd_header X-Frame-Options \"DENY\" always;\n"+
+			"}\n"+
+			"location ^~ \"/css/\" {\n"+
+			"\tadd_header Cache-Control "+
+			"\"public, max-age=31536000, immutable\" always;\n"+
+			"}\n")
+
+	c.Equal(
+		tmp.ReadFile("/public/.htaccess"),
+		""+
+			"<IfModule mod_headers.c>\n"+
+			"<If \"%{REQUEST_URI} == '/'\">\n"+
+			"\tHeader set X-Frame-Options \"DENY\"\n"+
+			"</If>\n"+
+			"<If \"%{REQUEST_URI} == '/about'\">\n"+
+			"\tHeader set Link \"</a>; rel=\\\"a\\\"\"\n"+
+			"\tHeader add Link \"</b>; rel=\\\"b\\\"\"\n"+
+			"\tHeader set X-Frame-Options \"DENY\"\n"+
+			"</If>\n"+
+			"<If \"%{REQUEST_URI} =~ m#^/css/#\">\n"+
+			"\tHeader set Cache-Control "+
+			"\"public, max-age=31536000, immutable\"\n"+
+			"</If>\n"+
+			"</IfModule>\n")
+}
+
+func TestHeadersCollapse(t *testing.T) {
+	c := check.New(t)
+
+	same := http.Header{"Cache-Control": {"no-cache"}}
+	other := http.Header{"Cache-Control": {"no-store"}}
+
+	root := newHeaderNode()
+	root.add("/", same)
+	root.add("/a/1", same)
+	root.add("/a/2", same)
+	root.add("/b/1", same)
+	root.add("/b/2", other)
+	root.add("/c/1", nil)
+	root.add("/c/2", nil)
+	root.add("/d/1", other)
+
+	var paths []string
+	for _, rule := range root.rules("/", true) {
+		path := rule.path
+		if rule.dir {
+			path += "*"
+		}
+
+		paths = append(paths, path)
+	}
+
+	c.Equal(
+		paths,
+		[]string{
+			"/",
+			"/a/*",
+			"/b/1",
+			"/b/2",
+			"/d/1", // A single page is never collapsed
+		})
+
+	// Everything the same collapses to the root
+	root = newHeaderNode()
+	root.add("/", same)
+	root.add("/a/1", same)
+
+	rules := root.rules("/", true)
+	c.Must.Len(rules, 1)
+	c.Equal(rules[0].path, "/")
+	c.True(rules[0].dir)
+}
+
+func TestHeadersEscape(t *testing.T) {
+	c := check.New(t)
+
+	h := http.Header{"X-Test": {`50% "off"`}}
+	rules := []headerRule{
+		{path: "/it's", header: h},
+		{path: "/%23hash/", dir: true, header: h},
+		{path: `/a%5C%22b`, header: h},
+	}
+
+	c.Equal(
+		string(apacheHeaders(rules)),
+		""+
+			"<IfModule mod_headers.c>\n"+
+			"<If \"%{REQUEST_URI} == '/it\\'s'\">\n"+
+			"\tHeader set X-Test \"50%% \\\"off\\\"\"\n"+
+			"</If>\n"+
+			"<If \"%{REQUEST_URI} =~ m#^/\\x23hash/#\">\n"+
+			"\tHeader set X-Test \"50%% \\\"off\\\"\"\n"+
+			"</If>\n"+
+			"<If \"%{REQUEST_URI} == '/a\\\\\\\"b'\">\n"+
+			"\tHeader set X-Test \"50%% \\\"off\\\"\"\n"+
+			"</If>\n"+
+			"</IfModule>\n")
+
+	c.Equal(
+		string(nginxHeaders(rules[1:2])),
+		""+
+			"location ^~ \"/#hash/\" {\n"+
+			"\tadd_header X-Test \"50% \\\"off\\\"\" always;\n"+
+			"}\n")
+}
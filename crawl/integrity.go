@@ -0,0 +1,137 @@
+package crawl
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/base64"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+func integrity(body *responseBody) (string, error) {
+	r, err := body.reader()
+	if err != nil {
+		return "", err
+	}
+
+	defer r.Close()
+
+	hash := sha512.New384()
+
+	_, err = io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+
+	return "sha384-" + base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+func transformIntegrity(lr LinkResolver, b []byte) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	_, lr = htmlBase(lr, doc)
+
+	var cbs []func()
+
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		for cn := n.FirstChild; cn != nil; cn = cn.NextSibling {
+			visit(cn)
+		}
+
+		link := integrityLink(n)
+		if link == "" {
+			return
+		}
+
+		res := lr.ResolveLink(link)
+		cbs = append(cbs, func() {
+			target, ok := res.Target()
+			if !ok || target.Integrity == "" {
+				return
+			}
+
+			n.Attr = append(n.Attr, html.Attribute{
+				Key: "integrity",
+				Val: target.Integrity,
+			})
+
+			// Resources on another origin (eg. from a Variant that moved it to
+			// a CDN) have to be fetched with CORS for the browser to be able to
+			// check them.
+			if isAbsURL(target.URL) && getAttr(n, "crossorigin") == nil {
+				n.Attr = append(n.Attr, html.Attribute{
+					Key: "crossorigin",
+					Val: "anonymous",
+				})
+			}
+		})
+	}
+
+	visit(doc)
+	for _, cb := range cbs {
+		cb()
+	}
+
+	var buff bytes.Buffer
+	err = html.Render(&buff, doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// integrityLink gets the link an integrity attribute should be added for, if
+// any
+func integrityLink(n *html.Node) string {
+	if n.Type != html.ElementNode || getAttr(n, "integrity") != nil {
+		return ""
+	}
+
+	var attr *html.Attribute
+
+	switch n.DataAtom {
+	case atom.Script:
+		attr = getAttr(n, "src")
+
+	case atom.Link:
+		rel := getAttr(n, "rel")
+		if rel == nil {
+			return ""
+		}
+
+		for _, r := range strings.Fields(strings.ToLower(rel.Val)) {
+			switch r {
+			case "stylesheet", "preload", "modulepreload":
+				attr = getAttr(n, "href")
+			}
+		}
+	}
+
+	if attr == nil {
+		return ""
+	}
+
+	return attr.Val
+}
+
+func getAttr(n *html.Node, key string) *html.Attribute {
+	for i := range n.Attr {
+		if n.Attr[i].Namespace == "" && n.Attr[i].Key == key {
+			return &n.Attr[i]
+		}
+	}
+
+	return nil
+}
+
+func isAbsURL(u string) bool {
+	return strings.HasPrefix(u, "//") || strings.Contains(u, "://")
+}
@@ -0,0 +1,81 @@
+package crawl
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestIntegrityBasic(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body: `` +
+					`<link href="/all.css" rel="stylesheet">` +
+					`<link href="/img.gif" rel="icon">` +
+					`<script src="/app.js"></script>` +
+					`<script src="/cdn.js"></script>` +
+					`<script src="/mine.js" integrity="sha384-mine"></script>`,
+			},
+			"/all.css": stringHandler{
+				contType: cssType,
+				body:     `body { background: url(/img.gif); }`,
+			},
+			"/img.gif": stringHandler{
+				contType: testutil.GifType,
+				body:     string(testutil.GifBin),
+			},
+			"/app.js": stringHandler{
+				contType: mime.TypeByExtension(".js"),
+				body:     `alert(1);`,
+			},
+			"/cdn.js": http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					Variant(w, "https://cdn.example.com/cdn.js")
+					stringHandler{
+						contType: mime.TypeByExtension(".js"),
+						body:     `alert(2);`,
+					}.ServeHTTP(w, r)
+				}),
+			"/mine.js": stringHandler{
+				contType: mime.TypeByExtension(".js"),
+				body:     `alert(3);`,
+			},
+		}),
+		Integrity(),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Fingerprint(func(u *url.URL, mediaType string) bool {
+			return filepath.Ext(u.Path) == ".css"
+		}))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	sri := func(path string) string {
+		sri, err := integrity(&responseBody{
+			b: []byte(tmp.ReadFile(filepath.Join("/public", path))),
+		})
+		c.Must.Nil(err)
+		return sri
+	}
+
+	allCSS := site.GetPage("/all.css")
+	index := tmp.ReadFile("/public/index.html")
+
+	c.Contains(index, `integrity=`+sri(allCSS.URL.Path))
+	c.Contains(index, `integrity=`+sri("/app.js"))
+	c.Contains(index, `integrity=`+sri("/cdn.js")+` crossorigin=anonymous`)
+	c.Contains(index, `integrity=sha384-mine>`)
+	c.NotContains(index, `integrity=`+sri("/img.gif"))
+}
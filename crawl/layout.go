@@ -0,0 +1,66 @@
+package crawl
+
+import (
+	"path"
+	"strings"
+)
+
+// A Layout determines how the URLs of HTML pages map to output files
+type Layout int
+
+const (
+	// LayoutStrict maps URLs to files exactly as they are: /foo/ is written to
+	// foo/index.html, and /foo to a file named foo. Since most static servers
+	// pick a Content-Type by extension, extensionless HTML pages are likely to
+	// be served with the wrong one (see Site.Warnings).
+	LayoutStrict Layout = iota
+
+	// LayoutDirectory writes extensionless HTML pages as directories: both /foo
+	// and /foo/ are written to foo/index.html, and /foo/ is the canonical URL.
+	LayoutDirectory
+
+	// LayoutFlat writes extensionless HTML pages as .html files: both /foo and
+	// /foo/ are written to foo.html, and /foo is the canonical URL. The root is
+	// always index.html.
+	LayoutFlat
+)
+
+// canonical gets the canonical form of the given url.Path
+func (layout Layout) canonical(urlPath, mediaType string) string {
+	if mediaType != htmlType || urlPath == "/" || !layoutApplies(urlPath) {
+		return urlPath
+	}
+
+	switch layout {
+	case LayoutDirectory:
+		if !strings.HasSuffix(urlPath, "/") {
+			urlPath += "/"
+		}
+
+	case LayoutFlat:
+		urlPath = strings.TrimSuffix(urlPath, "/")
+	}
+
+	return urlPath
+}
+
+// file gets the url.Path of the file that serves the given canonical
+// url.Path
+func (layout Layout) file(urlPath, mediaType string) string {
+	if layout == LayoutFlat &&
+		mediaType == htmlType &&
+		urlPath != "/" &&
+		!strings.HasSuffix(urlPath, "/") &&
+		layoutApplies(urlPath) {
+
+		urlPath += ".html"
+	}
+
+	return urlPath
+}
+
+// layoutApplies determines if the given url.Path is an extensionless page
+// that a Layout can move around
+func layoutApplies(urlPath string) bool {
+	return path.Ext(strings.TrimSuffix(urlPath, "/")) == ""
+}
@@ -0,0 +1,242 @@
+package crawl
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestLayout(t *testing.T) {
+	c := check.New(t)
+
+	page := stringHandler{
+		contType: htmlType,
+		body:     `page`,
+	}
+
+	handler := mux(map[string]http.Handler{
+		"/": stringHandler{
+			contType: htmlType,
+			body: `` +
+				`<a href="/about">` +
+				`<a href="/blog/">` +
+				`<a href="/both">` +
+				`<a href="/both/">` +
+				`<a href="/page.html">` +
+				`<a href="/feed">`,
+		},
+		"/about":     page,
+		"/blog/":     page,
+		"/both":      page,
+		"/both/":     page,
+		"/page.html": page,
+		"/feed": stringHandler{
+			contType: "application/atom+xml",
+			body:     `<feed></feed>`,
+		},
+	})
+
+	tests := []struct {
+		layout    Layout
+		err       bool
+		files     []string
+		links     []string
+		redirects string
+	}{
+		{
+			// /both can't be a file and a dir at the same time
+			layout: LayoutStrict,
+			err:    true,
+		},
+		{
+			layout: LayoutDirectory,
+			files: []string{
+				"/about/index.html",
+				"/blog/index.html",
+				"/both/index.html",
+			},
+			links: []string{
+				`href=/about/>`,
+				`href=/blog/>`,
+				`href=/both/>`,
+			},
+			redirects: "" +
+				"/about /about/ 301\n" +
+				"/both /both/ 301\n",
+		},
+		{
+			layout: LayoutFlat,
+			files: []string{
+				"/about.html",
+				"/blog.html",
+				"/both.html",
+			},
+			links: []string{
+				`href=/about>`,
+				`href=/blog>`,
+				`href=/both>`,
+			},
+			redirects: "" +
+				"/blog/ /blog 301\n" +
+				"/both/ /both 301\n",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		c.Run(fmt.Sprint(test.layout), func(c *check.C) {
+			tmp := testutil.NewTmpDir(c, nil)
+			defer tmp.Remove()
+
+			site, err := Crawl(handler,
+				Output(tmp.Path("/public")),
+				FingerprintCache(""),
+				OutputLayout(test.layout),
+				Redirects(RedirectNetlify))
+			if test.err {
+				c.Must.NotNil(err)
+				c.Contains(
+					err.Error(),
+					FileDirMismatchError(tmp.Path("/public/both")).Error())
+				return
+			}
+
+			c.Must.Nil(err)
+			tmp.DumpTree()
+
+			for _, file := range test.files {
+				c.Equal(tmp.ReadFile("/public"+file), `page`, file)
+			}
+
+			// Only extensionless HTML is moved
+			c.Equal(tmp.ReadFile("/public/page.html"), `page`)
+			c.Equal(tmp.ReadFile("/public/feed"), `<feed></feed>`)
+
+			index := tmp.ReadFile("/public/index.html")
+			for _, link := range test.links {
+				c.Contains(index, link)
+			}
+
+			c.Contains(index, `href=/page.html>`)
+			c.Contains(index, `href=/feed>`)
+
+			c.Equal(tmp.ReadFile("/public/_redirects"), test.redirects)
+
+			// Only the feed can't be served with the right Content-Type
+			warnings := site.Warnings()
+			c.Len(warnings, 1)
+			c.Len(warnings["/feed"], 1)
+		})
+	}
+}
+
+func TestLayoutRedirectStubs(t *testing.T) {
+	c := check.New(t)
+
+	page := stringHandler{
+		contType: htmlType,
+		body:     `page`,
+	}
+
+	handler := mux(map[string]http.Handler{
+		"/": stringHandler{
+			contType: htmlType,
+			body:     `<a href="/about">` + `<a href="/blog/">`,
+		},
+		"/about": page,
+		"/blog/": page,
+	})
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(handler,
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		OutputLayout(LayoutFlat),
+		Redirects(RedirectHTML))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	c.Equal(tmp.ReadFile("/public/blog.html"), `page`)
+	c.Contains(
+		tmp.ReadFile("/public/blog/index.html"),
+		`<meta http-equiv="refresh" content="0; url=/blog">`)
+
+	tmp = testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err = Crawl(handler,
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		OutputLayout(LayoutDirectory),
+		Redirects(RedirectHTML))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	c.Equal(tmp.ReadFile("/public/about/index.html"), `page`)
+	c.Equal(len(tmp.GetFiles()), 3)
+}
+
+func TestLayoutRedirectStubPaths(t *testing.T) {
+	c := check.New(t)
+
+	handler := mux(map[string]http.Handler{
+		"/": stringHandler{
+			contType: htmlType,
+			body:     `<a href="/old">` + `<a href="/gone/">`,
+		},
+		"/old":   http.RedirectHandler("/new.html", http.StatusMovedPermanently),
+		"/gone/": http.RedirectHandler("/new.html", http.StatusFound),
+		"/new.html": stringHandler{
+			contType: htmlType,
+			body:     `new`,
+		},
+	})
+
+	tests := []struct {
+		layout Layout
+		stubs  []string
+	}{
+		{
+			layout: LayoutStrict,
+			stubs:  []string{"/old", "/gone/index.html"},
+		},
+		{
+			layout: LayoutDirectory,
+			stubs:  []string{"/old/index.html", "/gone/index.html"},
+		},
+		{
+			layout: LayoutFlat,
+			stubs:  []string{"/old.html", "/gone.html"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		c.Run(fmt.Sprint(test.layout), func(c *check.C) {
+			tmp := testutil.NewTmpDir(c, nil)
+			defer tmp.Remove()
+
+			_, err := Crawl(handler,
+				Output(tmp.Path("/public")),
+				FingerprintCache(""),
+				OutputLayout(test.layout),
+				Redirects(RedirectHTML))
+			c.Must.Nil(err)
+			tmp.DumpTree()
+
+			files := tmp.GetFiles()
+			c.Len(files, 4)
+
+			for _, stub := range test.stubs {
+				c.Contains(
+					files["/public"+stub],
+					`<meta http-equiv="refresh" content="0; url=/new.html">`)
+			}
+		})
+	}
+}
@@ -1,40 +1,148 @@
 package crawl
 
+import "net/url"
+
 // A LinkResolver resolves links asynchronously
 type LinkResolver interface {
 	ResolveLink(link string) ResolvedLinker
 
-	// TODO(as): does anyone actually use <base href="">?
-	// WithBase(base string) LinkResolver
+	// WithBase gets a LinkResolver that resolves relative links against the
+	// given base (eg. from <base href="">) rather than the current page's URL.
+	// The base itself is relative to the current resolver's base.
+	WithBase(base string) LinkResolver
+
+	// Warn reports a problem with the current page that isn't bad enough to
+	// fail the crawl. See Site.Warnings.
+	Warn(err error)
 }
 
 // A ResolvedLinker gets the results of an async link resolve
 type ResolvedLinker interface {
+	// Get gets the final URL of the link
 	Get() string
+
+	// Target gets details about the resource the link points to, waiting for
+	// the resource's output to be final. ok is false for external links and
+	// links that couldn't be resolved.
+	Target() (target LinkTarget, ok bool)
+}
+
+// A LinkTarget describes the final resource a link points to
+type LinkTarget struct {
+	URL         string // Final URL, as returned from Get()
+	MediaType   string // Media type of the resource
+	Fingerprint string // Fingerprint of the resource, if fingerprinted
+	Integrity   string // Subresource Integrity hash; needs Integrity()
 }
 
 // linkResolver implements LinkResolver so that Page doesn't expose it (since
 // it's technically only useful during a crawl, not after).
-type linkResolver Page
+type linkResolver struct {
+	pg   *Page
+	base *url.URL
+}
+
+func newLinkResolver(pg *Page) *linkResolver {
+	return &linkResolver{
+		pg:   pg,
+		base: &pg.OrigURL,
+	}
+}
+
+func (lr *linkResolver) WithBase(base string) LinkResolver {
+	baseURL, err := lr.base.Parse(base)
+	if err != nil {
+		lr.pg.addError(err)
+		return lr
+	}
+
+	return &linkResolver{
+		pg:   lr.pg,
+		base: baseURL,
+	}
+}
+
+func (lr *linkResolver) Warn(err error) {
+	lr.pg.addWarning(err)
+}
 
 func (lr *linkResolver) ResolveLink(link string) ResolvedLinker {
-	pg := (*Page)(lr)
+	pg := lr.pg
 	rl := resolvedLink{
 		orig: link,
 		from: pg,
 	}
 
-	relURL, err := pg.OrigURL.Parse(link)
+	relURL, err := lr.base.Parse(link)
 	if err != nil {
 		pg.addError(err)
+	} else if !pg.cr.crawlable(relURL) {
+		if pg.cr.filter.warn {
+			pg.addWarning(ExcludedLinkError(link))
+		}
 	} else {
 		rl.to = pg.cr.get(relURL)
 		rl.frag = relURL.Fragment
+
+		// Remember every link so that an Incremental crawl can tell if
+		// anything this page links to changed
+		if pg.cr.state.enabled {
+			pg.links = append(pg.links, &rl)
+		}
 	}
 
 	return &rl
 }
 
+// A linkLookuper can resolve links without crawling them
+type linkLookuper interface {
+	lookupLink(link string) ResolvedLinker
+}
+
+// lookupLink resolves a link that's only used to resolve other links (eg.
+// <base href="">), so it's never crawled: it's only rewritten if it points to
+// a page that's already part of the crawl, and it's otherwise left as-is.
+func lookupLink(lr LinkResolver, link string) ResolvedLinker {
+	if ll, ok := lr.(linkLookuper); ok {
+		return ll.lookupLink(link)
+	}
+
+	return unresolvedLink(link)
+}
+
+func (lr *linkResolver) lookupLink(link string) ResolvedLinker {
+	pg := lr.pg
+
+	relURL, err := lr.base.Parse(link)
+	if err != nil {
+		pg.addError(err)
+		return unresolvedLink(link)
+	}
+
+	to := pg.cr.lookup(relURL)
+	if to == nil {
+		return unresolvedLink(link)
+	}
+
+	return &resolvedLink{
+		orig: link,
+		from: pg,
+		to:   to,
+		frag: relURL.Fragment,
+	}
+}
+
+// unresolvedLink is a link that's left exactly as it is
+type unresolvedLink string
+
+func (link unresolvedLink) Get() string {
+	return string(link)
+}
+
+func (link unresolvedLink) Target() (LinkTarget, bool) {
+	return LinkTarget{}, false
+}
+
 type resolvedLink struct {
 	orig string
 	from *Page
@@ -43,16 +151,58 @@ type resolvedLink struct {
 }
 
 func (rl *resolvedLink) Get() string {
-	if rl.to == nil {
+	to := rl.follow()
+	if to == nil {
 		return rl.orig
 	}
 
-	to, err := rl.to.followRedirects()
+	return rl.url(to)
+}
+
+func (rl *resolvedLink) Target() (LinkTarget, bool) {
+	// Can't check IsExternal() since a Variant can move a page off-site
+	to := rl.follow()
+	if to == nil || to.loaded == nil {
+		return LinkTarget{}, false
+	}
+
+	// The output of an alias lives with the page it's an alias of
+	final := to
+	if final.AliasOf != nil {
+		final = final.AliasOf
+	}
+
+	err := rl.from.waitFor(final, waitDone)
 	if err != nil {
 		rl.from.addError(err)
-		return rl.orig
+		return LinkTarget{}, false
 	}
 
+	return LinkTarget{
+		URL:         rl.url(to),
+		MediaType:   final.MediaType,
+		Fingerprint: final.Fingerprint,
+		Integrity:   final.integrity,
+	}, true
+}
+
+// follow gets the page the link ultimately points to. Returns nil if the link
+// could not be resolved.
+func (rl *resolvedLink) follow() *Page {
+	if rl.to == nil {
+		return nil
+	}
+
+	to, err := rl.to.followRedirects(rl.from)
+	if err != nil {
+		rl.from.addError(err)
+		return nil
+	}
+
+	return to
+}
+
+func (rl *resolvedLink) url(to *Page) string {
 	uu := to.URL
 	uu.Fragment = rl.frag
 	return uu.String()
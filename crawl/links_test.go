@@ -11,8 +11,18 @@ func (lr linkRewrite) ResolveLink(link string) ResolvedLinker {
 	return rewrittenLink(to)
 }
 
+func (lr linkRewrite) WithBase(base string) LinkResolver {
+	return lr
+}
+
+func (lr linkRewrite) Warn(err error) {}
+
 type rewrittenLink string
 
 func (l rewrittenLink) Get() string {
 	return string(l)
 }
+
+func (l rewrittenLink) Target() (LinkTarget, bool) {
+	return LinkTarget{}, false
+}
@@ -0,0 +1,250 @@
+package crawl
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// manifestVersion is bumped any time the format of the manifest changes
+const manifestVersion = 3
+
+type manifest struct {
+	Version int             `json:"version"`
+	Output  string          `json:"output"` // Relative to the manifest's dir
+	Pages   []manifestEntry `json:"pages"`
+}
+
+type manifestEntry struct {
+	URL         string `json:"url"` // Original URL
+	FinalURL    string `json:"finalURL"`
+	Path        string `json:"path,omitempty"`       // Path it owns; see Site.GetPage
+	OutputPath  string `json:"outputPath,omitempty"` // Relative to Output
+	MediaType   string `json:"mediaType,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	Redirect    string `json:"redirect,omitempty"`  // Original URL of target
+	AliasOf     string `json:"aliasOf,omitempty"`   // Original URL of owner
+	ErrorPage   int    `json:"errorPage,omitempty"` // Status, if an ErrorPage
+}
+
+// claimManifest claims the manifest's file, if it's in the output, like any
+// other generated file, so that no page can be written over it
+func (cr *crawler) claimManifest() error {
+	path := absPath(cr.manifest)
+
+	rel, err := filepath.Rel(absPath(cr.output), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+
+	pg := &Page{
+		OrigURL:    url.URL{Path: "/" + filepath.ToSlash(rel)},
+		OutputPath: path,
+		cr:         cr,
+	}
+
+	return cr.claimFile(pg, path)
+}
+
+func (cr *crawler) writeManifest() error {
+	output := absPath(cr.output)
+
+	relOutput, err := filepath.Rel(filepath.Dir(absPath(cr.manifest)), output)
+	if err != nil {
+		return err
+	}
+
+	m := manifest{
+		Version: manifestVersion,
+		Output:  filepath.ToSlash(relOutput),
+	}
+
+	// Pages are claimed by their path before fingerprinting, which can't be
+	// worked out from the final URL
+	paths := make(map[*Page]string, len(cr.site.pages))
+	for path, pg := range cr.site.pages {
+		paths[pg] = path
+	}
+
+	for _, pg := range cr.site.urls {
+		if pg.IsExternal() {
+			continue
+		}
+
+		entry := manifestEntry{
+			URL:         pg.OrigURL.String(),
+			FinalURL:    pg.URL.String(),
+			Path:        paths[pg],
+			MediaType:   pg.MediaType,
+			Fingerprint: pg.Fingerprint,
+			Size:        pg.Size,
+			Status:      pg.Status,
+			ErrorPage:   pg.errStatus,
+		}
+
+		if pg.OutputPath != "" {
+			rel, err := filepath.Rel(output, pg.OutputPath)
+			if err != nil {
+				return err
+			}
+
+			entry.OutputPath = filepath.ToSlash(rel)
+		}
+
+		if pg.Redirect != nil {
+			entry.Redirect = pg.Redirect.OrigURL.String()
+		}
+
+		if pg.AliasOf != nil {
+			entry.AliasOf = pg.AliasOf.OrigURL.String()
+		}
+
+		m.Pages = append(m.Pages, entry)
+	}
+
+	sort.Slice(m.Pages, func(i, j int) bool {
+		return m.Pages[i].URL < m.Pages[j].URL
+	})
+
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	b = append(b, '\n')
+	return cr.writer.writeFile(cr.manifest, b)
+}
+
+// LoadManifest reads a manifest written by a previous crawl (see Manifest). The
+// returned Site can be queried just like one returned from Crawl.
+func LoadManifest(path string) (Site, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Site{}, err
+	}
+
+	var m manifest
+	err = json.Unmarshal(b, &m)
+	if err != nil {
+		return Site{}, err
+	}
+
+	if m.Version != manifestVersion {
+		return Site{}, ManifestVersionError{
+			Path:    path,
+			Version: m.Version,
+		}
+	}
+
+	site := Site{
+		urls:     make(map[string]*Page, len(m.Pages)),
+		pages:    make(map[string]*Page),
+		claims:   make(map[string]*Page),
+		errPages: make(map[int]*Page),
+	}
+
+	output := filepath.Join(
+		filepath.Dir(absPath(path)),
+		filepath.FromSlash(m.Output))
+
+	pages := make([]*Page, len(m.Pages))
+	for i, entry := range m.Pages {
+		pg, err := entry.page(output)
+		if err != nil {
+			return Site{}, err
+		}
+
+		pages[i] = pg
+		site.urls[pg.OrigURL.String()] = pg
+	}
+
+	// Redirects and aliases can only be linked up once all pages exist
+	for i, entry := range m.Pages {
+		pg := pages[i]
+
+		if entry.Redirect != "" {
+			pg.Redirect, err = site.manifestPage(entry.Redirect)
+			if err != nil {
+				return Site{}, err
+			}
+		}
+
+		if entry.AliasOf != "" {
+			pg.AliasOf, err = site.manifestPage(entry.AliasOf)
+			if err != nil {
+				return Site{}, err
+			}
+		}
+
+		if entry.ErrorPage != 0 {
+			site.errPages[entry.ErrorPage] = pg
+		}
+
+		if entry.Path != "" {
+			site.pages[entry.Path] = pg
+		}
+
+		if pg.OutputPath == "" || pg.Redirect != nil || entry.ErrorPage != 0 {
+			continue
+		}
+
+		if pg.AliasOf == nil {
+			site.claims[pg.OutputPath] = pg
+		}
+	}
+
+	return site, nil
+}
+
+func (entry manifestEntry) page(output string) (*Page, error) {
+	origURL, err := url.Parse(entry.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	finalURL, err := url.Parse(entry.FinalURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pg := &Page{
+		OrigURL:     *origURL,
+		URL:         *finalURL,
+		MediaType:   entry.MediaType,
+		Fingerprint: entry.Fingerprint,
+		Size:        entry.Size,
+		Status:      entry.Status,
+	}
+
+	if entry.OutputPath != "" {
+		pg.OutputPath = filepath.Join(output, filepath.FromSlash(entry.OutputPath))
+	}
+
+	return pg, nil
+}
+
+// manifestPage gets the page with the given original URL. External pages
+// aren't in manifests, so they're created as needed.
+func (s *Site) manifestPage(rawURL string) (*Page, error) {
+	if pg, ok := s.urls[rawURL]; ok {
+		return pg, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	pg := &Page{
+		OrigURL: *u,
+		URL:     *u,
+	}
+
+	s.urls[rawURL] = pg
+	return pg, nil
+}
@@ -0,0 +1,203 @@
+package crawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestManifestBasic(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	manifestPath := tmp.Path("/public/manifest.json")
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body: `` +
+					`<link href="/all.css" rel="stylesheet">` +
+					`<a href="/r/"></a>` +
+					`<a href="/page/?p=1"></a>` +
+					`<a href="/page/?p=2"></a>`,
+			},
+			"/all.css": stringHandler{
+				contType: cssType,
+				body:     `body { background: #000; }`,
+			},
+			"/r/": http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					http.Redirect(w, r, "/page/", http.StatusFound)
+				}),
+			"/page/": stringHandler{
+				contType: htmlType,
+				body:     `page`,
+			},
+			fmt.Sprintf(errorPageURL, http.StatusNotFound): http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", htmlType)
+					w.WriteHeader(http.StatusNotFound)
+					io.WriteString(w, `not found`)
+				}),
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Fingerprint(func(u *url.URL, mediaType string) bool {
+			return filepath.Ext(u.Path) == ".css"
+		}),
+		ErrorPage(http.StatusNotFound, "/404.html"),
+		Manifest(manifestPath))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	var m manifest
+	err = json.Unmarshal([]byte(tmp.ReadFile("/public/manifest.json")), &m)
+	c.Must.Nil(err)
+
+	c.Equal(m.Version, manifestVersion)
+	c.Equal(m.Output, ".")
+
+	var urls []string
+	for _, entry := range m.Pages {
+		urls = append(urls, entry.URL)
+	}
+
+	c.Equal(urls, []string{
+		"/",
+		"/.acrylic-error-page-404",
+		"/all.css",
+		"/page/",
+		"/page/?p=1",
+		"/page/?p=2",
+		"/r/",
+	})
+
+	loaded, err := LoadManifest(manifestPath)
+	c.Must.Nil(err)
+
+	allCSS := site.GetPage("/all.css")
+	lAllCSS := loaded.Get(&url.URL{Path: "/all.css"})
+	c.Must.NotNil(lAllCSS)
+	c.Equal(lAllCSS.URL, allCSS.URL)
+	c.Equal(lAllCSS.OutputPath, allCSS.OutputPath)
+	c.Equal(lAllCSS.Fingerprint, allCSS.Fingerprint)
+	c.Equal(lAllCSS.MediaType, cssType)
+	c.Equal(lAllCSS.Size, allCSS.Size)
+	c.NotEqual(lAllCSS.Size, 0)
+	c.Equal(loaded.GetPage("/all.css"), lAllCSS)
+	c.Equal(loaded.GetFile(allCSS.OutputPath), lAllCSS)
+
+	c.Equal(
+		loaded.Get(&url.URL{Path: "/r/"}).FollowRedirects().OrigURL.String(),
+		"/page/")
+
+	// Whichever of the /page/ variants claimed the output first owns it
+	owner := loaded.GetPage("/page/")
+	c.Must.NotNil(owner)
+	c.True(owner.AliasOf == nil)
+
+	for _, q := range []string{"", "p=1", "p=2"} {
+		pg := loaded.Get(&url.URL{Path: "/page/", RawQuery: q})
+		c.Must.NotNil(pg)
+		c.Equal(pg.OutputPath, owner.OutputPath)
+		if pg != owner {
+			c.Equal(pg.AliasOf, owner)
+		}
+	}
+
+	notFound := loaded.GetErrorPage(http.StatusNotFound)
+	c.Must.NotNil(notFound)
+	c.Equal(notFound.OutputPath, absPath(tmp.Path("/public/404.html")))
+	c.Equal(notFound.Status, http.StatusNotFound)
+}
+
+func TestManifestRelative(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	handler := mux(map[string]http.Handler{
+		"/": stringHandler{
+			contType: htmlType,
+			body:     `index`,
+		},
+	})
+
+	_, err := Crawl(handler,
+		Output(tmp.Path("/build/public")),
+		FingerprintCache(""),
+		Manifest(tmp.Path("/build/manifest.json")))
+	c.Must.Nil(err)
+
+	manifest := tmp.ReadFile("/build/manifest.json")
+	c.NotContains(manifest, tmp.Path(""))
+	c.Contains(manifest, `"output": "public"`)
+
+	// Still loads once moved along with the output
+	err = os.Rename(tmp.Path("/build"), tmp.Path("/moved"))
+	c.Must.Nil(err)
+
+	loaded, err := LoadManifest(tmp.Path("/moved/manifest.json"))
+	c.Must.Nil(err)
+	c.Equal(
+		loaded.GetPage("/").OutputPath,
+		tmp.Path("/moved/public/index.html"))
+}
+
+func TestManifestClaimCollision(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/manifest.json">manifest</a>`,
+			},
+			"/manifest.json": stringHandler{
+				contType: "application/json",
+				body:     `{}`,
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		Manifest(tmp.Path("/public/manifest.json")))
+
+	c.Equal(err, SiteError{
+		tmp.Path("/public/manifest.json"): {
+			FileAlreadyClaimedError{
+				File:     tmp.Path("/public/manifest.json"),
+				OwnerURL: "/manifest.json",
+			},
+		},
+	})
+}
+
+func TestManifestVersion(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"manifest.json": `{"version": 999}`,
+	})
+	defer tmp.Remove()
+
+	_, err := LoadManifest(tmp.Path("manifest.json"))
+	c.Equal(err, ManifestVersionError{
+		Path:    tmp.Path("manifest.json"),
+		Version: 999,
+	})
+}
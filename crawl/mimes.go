@@ -13,7 +13,10 @@ const (
 	htmlType = "text/html"
 	cssType  = "text/css"
 	jsType   = "application/javascript"
+	jsText   = "text/javascript" // Preferred by RFC 9239
 	jsonType = "application/json"
+	ldType   = "application/ld+json"
+	manType  = "application/manifest+json"
 	svgType  = "image/svg+xml"
 )
 
@@ -1,7 +1,10 @@
 package crawl
 
 import (
+	"hash"
+	"net/http"
 	"net/url"
+	"time"
 )
 
 // An Option is passed to Crawl() to change default options
@@ -27,6 +30,16 @@ func Output(path string) Option {
 	})
 }
 
+// OutputTo writes generated files to the given OutputFS rather than to the
+// directory set by Output. Output still determines each Page's OutputPath, and
+// anything configured with a path under Output (eg. a Manifest) goes to the
+// OutputFS too.
+func OutputTo(fs OutputFS) Option {
+	return option(func(cr *crawler) {
+		cr.writer.fs = fs
+	})
+}
+
 // Transforms appends the given transforms to any existing transforms.
 // Transforms are looked up by media type (eg. "text/html", not "text/html;
 // charset=utf-8").
@@ -38,6 +51,46 @@ func Transforms(transforms map[string][]Transform) Option {
 	})
 }
 
+// HTMLAttr adds an attribute to the set of HTML attributes that hold links (eg.
+// "data-src" for lazy loaders). elem may be "*" to match every element, and
+// attr may be namespaced (eg. "xlink:href"). Adding an existing pair changes
+// its kind.
+func HTMLAttr(elem, attr string, kind AttrKind) Option {
+	return option(func(cr *crawler) {
+		if cr.htmlAttrs == nil {
+			cr.htmlAttrs = defaultHTMLAttrs.clone()
+		}
+
+		cr.htmlAttrs.add(elem, attr, kind)
+	})
+}
+
+// JSONLinks resolves the strings at the given paths in JSON documents of the
+// given media type as links. Paths are keys separated by dots, with "[]" for
+// any array element and "**" for any number of levels (eg. "icons[].src" or
+// "**.href"). Web app manifests and JSON-LD are handled by default.
+func JSONLinks(mediaType string, paths ...string) Option {
+	return option(func(cr *crawler) {
+		cr.addTransforms(mediaType, transformJSONLinks(paths...))
+	})
+}
+
+// DryRun runs the full crawl without changing anything on the filesystem. What
+// would have been written, symlinked, and removed is available from
+// Site.DryRunReport.
+func DryRun() Option {
+	return option(func(cr *crawler) {
+		cr.writer.dryRun = true
+	})
+}
+
+// StrictWarnings turns every warning into an error, failing the crawl
+func StrictWarnings() Option {
+	return option(func(cr *crawler) {
+		cr.strictWarnings = true
+	})
+}
+
 // Fingerprint sets the callback that determines if a resource should be
 // fingerprinted
 func Fingerprint(cb func(u *url.URL, mediaType string) bool) Option {
@@ -46,6 +99,27 @@ func Fingerprint(cb func(u *url.URL, mediaType string) bool) Option {
 	})
 }
 
+// FingerprintHash sets the hash used to fingerprint resources, and how many
+// hex digits of the digest to keep (0 keeps all of them). The default is SHA-1
+// with the full digest.
+//
+// Keep in mind that shorter fingerprints make it more likely that a changed
+// resource keeps its fingerprint, so browsers keep using a stale copy.
+func FingerprintHash(newHash func() hash.Hash, length int) Option {
+	return option(func(cr *crawler) {
+		cr.fingerprints.newHash = newHash
+		cr.fingerprints.length = length
+	})
+}
+
+// FingerprintPlacement sets where fingerprints go in URLs. The default is
+// FingerprintName.
+func FingerprintPlacement(place FingerprintPlace) Option {
+	return option(func(cr *crawler) {
+		cr.fingerprints.place = place
+	})
+}
+
 // FingerprintCache sets the file where the fingerprint cache should be written.
 // Set to "" to disable caching.
 //
@@ -63,3 +137,260 @@ func CleanDirs(dirs ...string) Option {
 		cr.cleanDirs = append(cr.cleanDirs, dirs...)
 	})
 }
+
+// Concurrency limits the number of pages that may be loaded at once. Set to 0
+// for no limit (the default).
+func Concurrency(n int) Option {
+	return option(func(cr *crawler) {
+		cr.slots = nil
+		if n > 0 {
+			cr.slots = make(chan struct{}, n)
+		}
+	})
+}
+
+// PageTimeout sets how long a handler may take to respond to a single request
+// before the page is marked as failed. Set to 0 for no timeout (the default).
+//
+// The handler's request context is cancelled when the timeout passes, but the
+// crawler does not wait for the handler to return.
+func PageTimeout(d time.Duration) Option {
+	return option(func(cr *crawler) {
+		cr.pageTimeout = d
+	})
+}
+
+// ErrorPage renders the page a handler sends back for the given status (eg.
+// 404) to the given path in the output. The crawler requests a URL that
+// doesn't exist, and instead of failing the crawl because of the status, it
+// transforms and writes out the response like any other page. The error page
+// is then available from Site.GetErrorPage.
+//
+// For statuses other than 404, use ErrorPageStatus from the handler to know
+// which status the crawler expects.
+func ErrorPage(status int, path string) Option {
+	return option(func(cr *crawler) {
+		if cr.errorPages == nil {
+			cr.errorPages = make(map[int]string)
+		}
+
+		cr.errorPages[status] = path
+	})
+}
+
+// Sitemap writes a sitemap.xml to the root of the output directory. It lists
+// every rendered HTML page, skipping redirects and aliases, with each page's
+// final URL under baseURL (eg. with "https://example.com/blog/", /post/ is
+// listed as https://example.com/blog/post/).
+//
+// If there are more URLs than a single sitemap may hold, the URLs are split
+// across sitemap-1.xml, sitemap-2.xml, etc., and sitemap.xml becomes a sitemap
+// index.
+func Sitemap(baseURL string) Option {
+	return option(func(cr *crawler) {
+		cr.generators = append(cr.generators, func(cr *crawler) {
+			err := genSitemap(cr, baseURL)
+			if err != nil {
+				cr.addError(url.URL{Path: sitemapPath}, err)
+			}
+		})
+	})
+}
+
+// Redirects writes something out for every Page that redirects, so that links
+// to the original URL from outside the site don't break. It may be given more
+// than once to write multiple formats.
+//
+// Redirects from URLs with query strings are skipped since there's no way to
+// represent them with static files.
+func Redirects(mode RedirectMode) Option {
+	return option(func(cr *crawler) {
+		cr.generators = append(cr.generators, func(cr *crawler) {
+			genRedirects(cr, mode)
+		})
+	})
+}
+
+// Precompress writes a compressed copy of every output file next to the
+// original (eg. "all.css.gz" next to "all.css") with each of the given
+// Compressors (eg. Gzip and Brotli).
+//
+// Only files of at least minSize bytes with one of the given media types are
+// compressed. If no media types are given, then every file is compressed.
+// Files are only compressed again once the original changes (or the
+// compressed copy goes missing).
+func Precompress(comps []Compressor, minSize int64, mediaTypes []string) Option {
+	return option(func(cr *crawler) {
+		cr.precompress = precompressor{
+			comps:      comps,
+			minSize:    minSize,
+			mediaTypes: make(map[string]struct{}),
+		}
+
+		for _, mediaType := range mediaTypes {
+			cr.precompress.mediaTypes[mediaType] = struct{}{}
+		}
+	})
+}
+
+// Integrity adds Subresource Integrity attributes to every <script src> and
+// <link rel="stylesheet|preload|modulepreload" href> that points to a page on
+// the site. Elements that already have an integrity attribute are left alone.
+//
+// Since the hash has to cover the final bytes of the linked resource, HTML
+// pages wait for everything they link to in this way to be completely
+// rendered.
+func Integrity() Option {
+	return option(func(cr *crawler) {
+		cr.integrity = true
+		cr.addTransforms(htmlType, transformIntegrity)
+	})
+}
+
+// Manifest writes a JSON description of every page on the site to the given
+// file after each successful crawl. Pages are sorted by original URL so that
+// diffs between builds are stable. Use LoadManifest to read it back.
+//
+// Every path in the manifest is relative, so builds on different machines
+// produce the same manifest, and it still loads if the manifest and output are
+// moved together.
+func Manifest(path string) Option {
+	return option(func(cr *crawler) {
+		cr.manifest = path
+		cr.generators = append(cr.generators, func(cr *crawler) {
+			err := cr.claimManifest()
+			if err != nil {
+				cr.addError(url.URL{Path: cr.manifest}, err)
+			}
+		})
+	})
+}
+
+// Events calls listener for every Event during a crawl. Calls are serialized,
+// so listener doesn't need to do any locking of its own, but it does block the
+// crawl while it runs.
+//
+// Nothing is written during a DryRun, so no EventWritten, EventUnchanged, or
+// EventCleaned are sent for those.
+func Events(listener func(ev Event)) Option {
+	return option(func(cr *crawler) {
+		cr.events.listeners = append(cr.events.listeners, listener)
+	})
+}
+
+// Incremental makes crawls reuse the output of the previous crawl for pages
+// that haven't changed. After every successful crawl, each page's validators
+// (ETag and Last-Modified) and outbound links are saved to stateFile. The next
+// crawl sends them back with If-None-Match and If-Modified-Since; if the
+// handler responds with 304 Not Modified, the page's previous output is used
+// instead of running its transforms again.
+//
+// A page is only reused if everything it links to resolves exactly as it did
+// before. If anything changed (eg. a fingerprinted image it links to), the page
+// is requested again, without the conditional headers, and rendered from
+// scratch.
+//
+// If stateFile is "", the state is kept next to the fingerprint cache. Since
+// the state only tracks what handlers return, it's best to remove it after
+// changing any options that affect output (eg. Transforms).
+//
+// Note: The file is a gzip-compressed .json file. Name it as you will.
+func Incremental(stateFile string) Option {
+	return option(func(cr *crawler) {
+		cr.state.enabled = true
+		cr.state.file = stateFile
+	})
+}
+
+// Include limits the crawl to links that match any of the given matchers. With
+// no Include, every link is crawled. Entry points and ErrorPages are always
+// crawled.
+//
+// Links that aren't crawled are left as-is.
+func Include(ms ...URLMatcher) Option {
+	return option(func(cr *crawler) {
+		cr.filter.include = append(cr.filter.include, ms...)
+	})
+}
+
+// Exclude skips crawling links that match any of the given matchers, even if
+// they match an Include. Entry points and ErrorPages are always crawled.
+//
+// Links that aren't crawled are left as-is.
+func Exclude(ms ...URLMatcher) Option {
+	return option(func(cr *crawler) {
+		cr.filter.exclude = append(cr.filter.exclude, ms...)
+	})
+}
+
+// WarnExcluded adds a warning for every link to a URL that isn't crawled
+// because of an Include, Exclude, or Robots. See Site.Warnings.
+func WarnExcluded() Option {
+	return option(func(cr *crawler) {
+		cr.filter.warn = true
+	})
+}
+
+// Robots makes the crawl honor the robots.txt served by the handler: links
+// that the rules for UserAgent (or "*", if there are none for it) disallow
+// aren't crawled, the same as with Exclude. If the handler doesn't serve a
+// robots.txt (responds with a 4xx), everything is allowed.
+//
+// Rules are matched per RFC 9309: the longest matching rule wins, with Allow
+// winning ties, and "*" and "$" are supported in paths.
+func Robots() Option {
+	return option(func(cr *crawler) {
+		cr.filter.useRobots = true
+	})
+}
+
+// OutputLayout sets how HTML pages are laid out in the output. The default is
+// LayoutStrict.
+//
+// Every link to an HTML page is rewritten to the page's canonical URL. With
+// Redirects, the non-canonical URL of each page that was requested that way
+// also gets a permanent redirect to the canonical URL (except with
+// RedirectHTML in LayoutDirectory, since static servers already redirect
+// directories to add the trailing slash).
+func OutputLayout(layout Layout) Option {
+	return option(func(cr *crawler) {
+		cr.layout = layout
+	})
+}
+
+// Headers writes out the given response headers (eg. "Cache-Control",
+// "Content-Security-Policy") from every rendered page, so that a static host
+// can send them too. It may be given more than once to write multiple formats.
+//
+// Rules are written for each page's URL. Directories where every page has the
+// same headers are collapsed into a single rule that matches everything under
+// the directory.
+func Headers(mode HeaderMode, names ...string) Option {
+	return option(func(cr *crawler) {
+		for _, name := range names {
+			cr.headers = append(cr.headers, http.CanonicalHeaderKey(name))
+		}
+
+		cr.generators = append(cr.generators, func(cr *crawler) {
+			genHeaders(cr, mode, names)
+		})
+	})
+}
+
+// SpillThreshold sets how large a response body may get before it's streamed
+// to a temp file in dir ("" for the system's temp dir) instead of being kept in
+// memory. Set to 0 to always keep bodies in memory. The default is 8 MiB.
+//
+// Only bodies that don't need to be transformed are spilled (eg. generated
+// archives or videos). They're hashed as they stream in, compared against the
+// existing output without reading them back into memory, and moved into place.
+// For the move to be a simple rename, dir should be on the same filesystem as
+// the output.
+func SpillThreshold(n int64, dir string) Option {
+	return option(func(cr *crawler) {
+		cr.spill = spillConfig{
+			threshold: n,
+			dir:       dir,
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package crawl
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// An OutputFS is where a crawl writes its output. Names are slash-separated
+// and relative to the root of the output (eg. "css/all.css"), with "." being
+// the root itself.
+//
+// Calls may come from many goroutines at once.
+type OutputFS interface {
+	// Equal determines if name is a file that contains exactly b. Symlinks are
+	// followed.
+	Equal(name string, b []byte) (bool, error)
+
+	// Exists determines if anything exists at name
+	Exists(name string) (bool, error)
+
+	// WriteFile writes b to name, replacing anything at name and creating any
+	// missing parent dirs.
+	WriteFile(name string, b []byte) error
+
+	// Symlink makes name serve the contents of src, an absolute path on the
+	// local filesystem, replacing anything at name. Implementations that
+	// can't link (eg. archives) copy the contents of src instead.
+	Symlink(src, name string) error
+
+	// Readlink gets the target of the symlink at name
+	Readlink(name string) (string, error)
+
+	// Walk calls fn for root and everything under it, parents before their
+	// children. If fn returns filepath.SkipDir for a dir, its contents are
+	// skipped. If root doesn't exist, fn is never called.
+	Walk(root string, fn func(name string, isDir bool) error) error
+
+	// RemoveAll removes name and everything under it
+	RemoveAll(name string) error
+}
+
+// An OutputReader is an OutputFS that can read back files. Incremental crawls
+// can only reuse output from an OutputFS that implements this.
+type OutputReader interface {
+	// ReadFile gets the contents of the file at name, following symlinks
+	ReadFile(name string) ([]byte, error)
+}
+
+// An OutputStreamer is an OutputFS that can write a file straight from a
+// reader. Bodies that were too big to keep in memory (see SpillThreshold) are
+// streamed to it instead of being read back into memory.
+type OutputStreamer interface {
+	// WriteFrom writes size bytes from r to name, like WriteFile
+	WriteFrom(name string, size int64, r io.Reader) error
+}
+
+// DirOutput writes output to a directory on the local filesystem. This is what
+// Output uses.
+func DirOutput(dir string) OutputFS {
+	return dirOutput(absPath(dir))
+}
+
+type dirOutput string
+
+func (d dirOutput) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+func (d dirOutput) Equal(name string, b []byte) (bool, error) {
+	return fileEquals(d.path(name), b)
+}
+
+func (d dirOutput) Exists(name string) (bool, error) {
+	_, err := os.Lstat(d.path(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (d dirOutput) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(name))
+}
+
+func (d dirOutput) WriteFile(name string, b []byte) error {
+	path := d.path(name)
+
+	err := filePrepWrite(path)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0666)
+}
+
+// moveFile moves src, a file on the local filesystem, to name. If src can't be
+// renamed (eg. it's on another filesystem), it's copied and removed.
+func (d dirOutput) moveFile(src, name string) error {
+	path := d.path(name)
+
+	err := filePrepWrite(path)
+	if err != nil {
+		return err
+	}
+
+	if os.Rename(src, path) == nil {
+		return nil
+	}
+
+	err = fileCopy(src, path)
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+func (d dirOutput) Symlink(src, name string) error {
+	path := d.path(name)
+
+	err := filePrepWrite(path)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(src, path)
+}
+
+func (d dirOutput) Readlink(name string) (string, error) {
+	return os.Readlink(d.path(name))
+}
+
+func (d dirOutput) Walk(root string, fn func(name string, isDir bool) error) error {
+	rootPath := d.path(root)
+
+	return filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Don't fail if the root directory doesn't exist
+			if path == rootPath && os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		rel, err := filepath.Rel(string(d), path)
+		if err != nil {
+			return err
+		}
+
+		return fn(filepath.ToSlash(rel), info.IsDir())
+	})
+}
+
+func (d dirOutput) RemoveAll(name string) error {
+	return os.RemoveAll(d.path(name))
+}
@@ -0,0 +1,171 @@
+package crawl
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// ArchiveOutput is an OutputFS that streams everything into an archive. Since
+// archives can't be changed once written, the archive always starts empty,
+// and symlinks are replaced with copies of what they point to.
+//
+// Close must be called after the crawl to finish the archive.
+type ArchiveOutput struct {
+	mtx   sync.Mutex
+	add   func(name string, size int64, r io.Reader) error
+	close func() error
+	names map[string]struct{}
+}
+
+// archiveModTime is used for every entry so that archives are reproducible
+var archiveModTime = time.Unix(0, 0).UTC()
+
+// TarOutput creates an ArchiveOutput that writes a tar archive to w
+func TarOutput(w io.Writer) *ArchiveOutput {
+	tw := tar.NewWriter(w)
+
+	return newArchiveOutput(
+		func(name string, size int64, r io.Reader) error {
+			err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     name,
+				Size:     size,
+				Mode:     0644,
+				ModTime:  archiveModTime,
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(tw, r)
+			return err
+		},
+		tw.Close)
+}
+
+// ZipOutput creates an ArchiveOutput that writes a zip archive to w
+func ZipOutput(w io.Writer) *ArchiveOutput {
+	zw := zip.NewWriter(w)
+
+	return newArchiveOutput(
+		func(name string, size int64, r io.Reader) error {
+			fw, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     name,
+				Method:   zip.Deflate,
+				Modified: archiveModTime,
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = io.Copy(fw, r)
+			return err
+		},
+		zw.Close)
+}
+
+func newArchiveOutput(
+	add func(name string, size int64, r io.Reader) error,
+	close func() error) *ArchiveOutput {
+
+	return &ArchiveOutput{
+		add:   add,
+		close: close,
+		names: make(map[string]struct{}),
+	}
+}
+
+// Close finishes the archive. It does not close the underlying writer.
+func (a *ArchiveOutput) Close() error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.close()
+}
+
+// Equal implements OutputFS. Since there's no going back to check what was
+// written, nothing is ever equal.
+func (a *ArchiveOutput) Equal(name string, b []byte) (bool, error) {
+	return false, nil
+}
+
+// Exists implements OutputFS
+func (a *ArchiveOutput) Exists(name string) (bool, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	_, ok := a.names[path.Clean(name)]
+	return ok, nil
+}
+
+// WriteFile implements OutputFS
+func (a *ArchiveOutput) WriteFile(name string, b []byte) error {
+	return a.write(name, int64(len(b)), bytes.NewReader(b))
+}
+
+// WriteFrom implements OutputStreamer
+func (a *ArchiveOutput) WriteFrom(name string, size int64, r io.Reader) error {
+	return a.write(name, size, r)
+}
+
+// Symlink implements OutputFS by copying src into the archive
+func (a *ArchiveOutput) Symlink(src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return a.write(name, info.Size(), f)
+}
+
+func (a *ArchiveOutput) write(name string, size int64, r io.Reader) error {
+	name = path.Clean(name)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if _, ok := a.names[name]; ok {
+		return fmt.Errorf("%s: already written to archive", name)
+	}
+
+	a.names[name] = struct{}{}
+	return a.add(name, size, r)
+}
+
+// Readlink implements OutputFS. Archives never contain symlinks.
+func (a *ArchiveOutput) Readlink(name string) (string, error) {
+	return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+}
+
+// Walk implements OutputFS. Since the archive starts out empty and everything
+// that's written to it is used, there's never anything to clean, so this does
+// nothing.
+func (a *ArchiveOutput) Walk(root string, fn func(name string, isDir bool) error) error {
+	return nil
+}
+
+// RemoveAll implements OutputFS. Anything that was written can't be removed.
+func (a *ArchiveOutput) RemoveAll(name string) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if _, ok := a.names[path.Clean(name)]; ok {
+		return fmt.Errorf("%s: can't remove from archive", name)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package crawl
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestOutputArchiveTar(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/stuff.txt": `stuff`,
+	})
+	defer tmp.Remove()
+
+	var buff bytes.Buffer
+	out := TarOutput(&buff)
+
+	_, err := Crawl(
+		outputHandler(tmp),
+		Output(tmp.Path("/public")),
+		OutputTo(out),
+		FingerprintCache(""))
+	c.Must.Nil(err)
+	c.Must.Nil(out.Close())
+
+	files := make(map[string]string)
+
+	tr := tar.NewReader(&buff)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		c.Must.Nil(err)
+		c.Equal(hdr.Typeflag, byte(tar.TypeReg))
+
+		b, err := ioutil.ReadAll(tr)
+		c.Must.Nil(err)
+		files[hdr.Name] = string(b)
+	}
+
+	c.Equal(files["dir/stuff.txt"], `stuff`)
+	c.Equal(files["all.css"], `body{background:#000}`)
+	c.Contains(files["index.html"], `/all.css`)
+	c.Len(files, 3)
+}
+
+func TestOutputArchiveZip(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/stuff.txt": `stuff`,
+	})
+	defer tmp.Remove()
+
+	var buff bytes.Buffer
+	out := ZipOutput(&buff)
+
+	_, err := Crawl(
+		outputHandler(tmp),
+		Output(tmp.Path("/public")),
+		OutputTo(out),
+		FingerprintCache(""),
+		Precompress([]Compressor{Gzip()}, 0, []string{cssType}))
+	c.Must.Nil(err)
+	c.Must.Nil(out.Close())
+
+	zr, err := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	c.Must.Nil(err)
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		c.Must.Nil(err)
+
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		c.Must.Nil(err)
+
+		files[f.Name] = string(b)
+	}
+
+	c.Equal(files["dir/stuff.txt"], `stuff`)
+	c.Equal(files["all.css"], `body{background:#000}`)
+	c.Contains(files, "all.css.gz")
+	c.Len(files, 4)
+}
+
+func TestOutputArchiveDuplicate(t *testing.T) {
+	c := check.New(t)
+
+	out := TarOutput(ioutil.Discard)
+	c.Nil(out.WriteFile("a", nil))
+	c.NotNil(out.WriteFile("a", nil))
+	c.NotNil(out.RemoveAll("a"))
+	c.Nil(out.RemoveAll("b"))
+}
@@ -0,0 +1,304 @@
+package crawl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemOutput is an OutputFS that keeps everything in memory. It's useful for
+// tests and for serving a build without touching disk.
+type MemOutput struct {
+	rwmtx sync.RWMutex
+	files map[string]memFile // By name
+	dirs  memDirs
+}
+
+// memDirs tracks the dirs that exist implicitly from the names of files: each
+// dir maps to the names of its direct children. The root is ".".
+type memDirs map[string]map[string]struct{}
+
+type memFile struct {
+	b    []byte
+	link string // Symlink target, if a symlink
+}
+
+// NewMemOutput creates a new, empty MemOutput
+func NewMemOutput() *MemOutput {
+	return &MemOutput{
+		files: make(map[string]memFile),
+		dirs:  make(memDirs),
+	}
+}
+
+// Names gets the names of every file, sorted
+func (m *MemOutput) Names() []string {
+	m.rwmtx.RLock()
+	defer m.rwmtx.RUnlock()
+
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// ReadFile gets the contents of the file at name. Symlinks are read from the
+// local filesystem.
+func (m *MemOutput) ReadFile(name string) ([]byte, error) {
+	name = path.Clean(name)
+
+	m.rwmtx.RLock()
+	f, ok := m.files[name]
+	m.rwmtx.RUnlock()
+
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	if f.link != "" {
+		return ioutil.ReadFile(f.link)
+	}
+
+	return f.b, nil
+}
+
+// ServeHTTP serves the files in m, the way a static file server would serve
+// the output dir
+func (m *MemOutput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := outputURLPath(r.URL.Path)
+	name = strings.TrimPrefix(path.Clean(name), "/")
+
+	b, err := m.ReadFile(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(b))
+}
+
+// Equal implements OutputFS
+func (m *MemOutput) Equal(name string, b []byte) (bool, error) {
+	fb, err := m.ReadFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return false, err
+	}
+
+	return bytes.Equal(fb, b), nil
+}
+
+// Exists implements OutputFS
+func (m *MemOutput) Exists(name string) (bool, error) {
+	name = path.Clean(name)
+
+	m.rwmtx.RLock()
+	defer m.rwmtx.RUnlock()
+
+	_, isFile := m.files[name]
+	_, isDir := m.dirs[name]
+
+	return isFile || isDir || name == ".", nil
+}
+
+// WriteFile implements OutputFS
+func (m *MemOutput) WriteFile(name string, b []byte) error {
+	m.put(name, memFile{
+		b: append([]byte(nil), b...),
+	})
+
+	return nil
+}
+
+// Symlink implements OutputFS
+func (m *MemOutput) Symlink(src, name string) error {
+	m.put(name, memFile{
+		link: src,
+	})
+
+	return nil
+}
+
+func (m *MemOutput) put(name string, f memFile) {
+	name = path.Clean(name)
+
+	m.rwmtx.Lock()
+	defer m.rwmtx.Unlock()
+
+	m.removeAll(name)
+
+	// A file can't also be a dir
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			m.removeAll(dir)
+		}
+	}
+
+	m.files[name] = f
+	m.dirs.add(name)
+}
+
+// Readlink implements OutputFS
+func (m *MemOutput) Readlink(name string) (string, error) {
+	name = path.Clean(name)
+
+	m.rwmtx.RLock()
+	f, ok := m.files[name]
+	m.rwmtx.RUnlock()
+
+	if !ok || f.link == "" {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrInvalid}
+	}
+
+	return f.link, nil
+}
+
+// Walk implements OutputFS
+func (m *MemOutput) Walk(root string, fn func(name string, isDir bool) error) error {
+	root = path.Clean(root)
+
+	type entry struct {
+		name  string
+		isDir bool
+	}
+
+	// Gather everything first so that fn is free to change m
+	var entries []entry
+
+	var walk func(name string)
+	walk = func(name string) {
+		kids, isDir := m.dirs[name]
+		entries = append(entries, entry{name: name, isDir: isDir})
+
+		names := make([]string, 0, len(kids))
+		for kid := range kids {
+			names = append(names, kid)
+		}
+
+		sort.Strings(names)
+		for _, kid := range names {
+			walk(kid)
+		}
+	}
+
+	m.rwmtx.RLock()
+
+	_, isFile := m.files[root]
+	_, isDir := m.dirs[root]
+	if isFile || isDir {
+		walk(root)
+	}
+
+	m.rwmtx.RUnlock()
+
+	var skipped []string
+
+outer:
+	for _, e := range entries {
+		for _, skip := range skipped {
+			if skip == "." || strings.HasPrefix(e.name, skip+"/") {
+				continue outer
+			}
+		}
+
+		err := fn(e.name, e.isDir)
+		if err == filepath.SkipDir {
+			skipped = append(skipped, e.name)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveAll implements OutputFS
+func (m *MemOutput) RemoveAll(name string) error {
+	m.rwmtx.Lock()
+	m.removeAll(path.Clean(name))
+	m.rwmtx.Unlock()
+
+	return nil
+}
+
+func (m *MemOutput) removeAll(name string) {
+	if name == "." {
+		m.files = make(map[string]memFile)
+		m.dirs = make(memDirs)
+		return
+	}
+
+	var remove func(name string)
+	remove = func(name string) {
+		delete(m.files, name)
+
+		for kid := range m.dirs[name] {
+			remove(kid)
+		}
+
+		delete(m.dirs, name)
+	}
+
+	_, isFile := m.files[name]
+	_, isDir := m.dirs[name]
+	if isFile || isDir {
+		remove(name)
+		m.dirs.unlink(name)
+	}
+}
+
+// add adds every parent dir of the file at name
+func (dirs memDirs) add(name string) {
+	for {
+		dir := path.Dir(name)
+
+		kids, ok := dirs[dir]
+		if !ok {
+			kids = make(map[string]struct{})
+			dirs[dir] = kids
+		}
+
+		kids[name] = struct{}{}
+
+		// If the dir already existed, so do all of its parents
+		if ok || dir == "." {
+			return
+		}
+
+		name = dir
+	}
+}
+
+// unlink removes name from its parent dir, removing any dirs that end up empty
+func (dirs memDirs) unlink(name string) {
+	for {
+		dir := path.Dir(name)
+
+		kids := dirs[dir]
+		delete(kids, name)
+
+		if len(kids) > 0 {
+			return
+		}
+
+		delete(dirs, dir)
+		if dir == "." {
+			return
+		}
+
+		name = dir
+	}
+}
@@ -0,0 +1,242 @@
+package crawl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+var outputHandler = func(tmp *testutil.TmpDir) http.Handler {
+	return mux(map[string]http.Handler{
+		"/": stringHandler{
+			contType: htmlType,
+			body: `` +
+				`<link href="/all.css" rel="stylesheet">` +
+				`<a href="/dir/stuff.txt"></a>`,
+		},
+		"/all.css": stringHandler{
+			contType: cssType,
+			body:     `body { background: #000; }`,
+		},
+		"/dir/stuff.txt": http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				ServeFile(w, r, tmp.Path("/stuff.txt"))
+			}),
+	})
+}
+
+func TestOutputMemBasic(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/stuff.txt": `stuff`,
+	})
+	defer tmp.Remove()
+
+	mem := NewMemOutput()
+	mem.WriteFile("stale/file.txt", []byte("stale"))
+	mem.WriteFile("stale.txt", []byte("stale"))
+
+	site, err := Crawl(
+		outputHandler(tmp),
+		Output(tmp.Path("/public")),
+		OutputTo(mem),
+		FingerprintCache(""),
+		Manifest(tmp.Path("/public/manifest.json")))
+	c.Must.Nil(err)
+
+	c.Equal(mem.Names(), []string{
+		"all.css",
+		"dir/stuff.txt",
+		"index.html",
+		"manifest.json",
+	})
+
+	// Nothing should have gone to disk
+	_, err = os.Stat(tmp.Path("/public"))
+	c.True(os.IsNotExist(err))
+
+	c.Equal(
+		site.GetPage("/all.css").OutputPath,
+		filepath.Join(absPath(tmp.Path("/public")), "all.css"))
+
+	link, err := mem.Readlink("dir/stuff.txt")
+	c.Must.Nil(err)
+	c.Equal(link, tmp.Path("/stuff.txt"))
+
+	b, err := mem.ReadFile("dir/stuff.txt")
+	c.Must.Nil(err)
+	c.Equal(string(b), `stuff`)
+
+	b, err = mem.ReadFile("all.css")
+	c.Must.Nil(err)
+	c.Equal(string(b), `body{background:#000}`)
+
+	srv := httptest.NewServer(mem)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	c.Must.Nil(err)
+	resp.Body.Close()
+	c.Equal(resp.StatusCode, http.StatusOK)
+	c.Equal(resp.Header.Get("Content-Type"), "text/html; charset=utf-8")
+
+	resp, err = http.Get(srv.URL + "/stale.txt")
+	c.Must.Nil(err)
+	resp.Body.Close()
+	c.Equal(resp.StatusCode, http.StatusNotFound)
+}
+
+func TestOutputMemDryRun(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/stuff.txt": `stuff`,
+	})
+	defer tmp.Remove()
+
+	mem := NewMemOutput()
+	mem.WriteFile("index.html", []byte("old"))
+	mem.WriteFile("stale/file.txt", []byte("stale"))
+
+	site, err := Crawl(
+		outputHandler(tmp),
+		Output(tmp.Path("/public")),
+		OutputTo(mem),
+		FingerprintCache(""),
+		DryRun())
+	c.Must.Nil(err)
+
+	public := absPath(tmp.Path("/public"))
+	report := site.DryRunReport()
+	c.Equal(report.Created, []string{filepath.Join(public, "all.css")})
+	c.Equal(report.Changed, []string{filepath.Join(public, "index.html")})
+	c.Equal(report.Symlinked, []string{filepath.Join(public, "dir/stuff.txt")})
+	c.Equal(report.Removed, []string{filepath.Join(public, "stale")})
+
+	c.Equal(mem.Names(), []string{"index.html", "stale/file.txt"})
+}
+
+func TestOutputMemWalk(t *testing.T) {
+	c := check.New(t)
+
+	mem := NewMemOutput()
+	mem.WriteFile("a.txt", nil)
+	mem.WriteFile("a/b/c.txt", nil)
+	mem.WriteFile("a/d.txt", nil)
+	mem.WriteFile("b/c.txt", nil)
+
+	var names []string
+	err := mem.Walk(".", func(name string, isDir bool) error {
+		names = append(names, name)
+		if name == "a/b" {
+			c.True(isDir)
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+	c.Must.Nil(err)
+	c.Equal(names, []string{".", "a", "a/b", "a/d.txt", "a.txt", "b", "b/c.txt"})
+
+	names = nil
+	err = mem.Walk("a", func(name string, isDir bool) error {
+		names = append(names, name)
+		return nil
+	})
+	c.Must.Nil(err)
+	c.Equal(names, []string{"a", "a/b", "a/b/c.txt", "a/d.txt"})
+
+	// Writing a file replaces any dir
+	mem.WriteFile("a", []byte("a"))
+	c.Equal(mem.Names(), []string{"a", "a.txt", "b/c.txt"})
+}
+
+func TestOutputMemRemove(t *testing.T) {
+	c := check.New(t)
+
+	mem := NewMemOutput()
+	mem.WriteFile("a/b/c.txt", nil)
+	mem.WriteFile("a/d.txt", nil)
+
+	tests := []struct {
+		name   string
+		exists bool
+	}{
+		{name: ".", exists: true},
+		{name: "a", exists: true},
+		{name: "a/b", exists: true},
+		{name: "a/b/c.txt", exists: true},
+		{name: "a/b/c", exists: false},
+		{name: "b", exists: false},
+	}
+
+	for _, test := range tests {
+		exists, err := mem.Exists(test.name)
+		c.Nil(err, test.name)
+		c.Equal(exists, test.exists, test.name)
+	}
+
+	// Dirs only exist while they have files
+	mem.RemoveAll("a/b/c.txt")
+
+	exists, err := mem.Exists("a/b")
+	c.Nil(err)
+	c.False(exists)
+
+	exists, err = mem.Exists("a")
+	c.Nil(err)
+	c.True(exists)
+
+	mem.RemoveAll("a")
+	c.Len(mem.Names(), 0)
+
+	var names []string
+	err = mem.Walk(".", func(name string, isDir bool) error {
+		names = append(names, name)
+		return nil
+	})
+	c.Nil(err)
+	c.Len(names, 0)
+}
+
+// uncomparableOutput is an OutputFS that can't be compared with ==
+type uncomparableOutput struct {
+	*MemOutput
+	opts map[string]string
+}
+
+func TestOutputUncomparable(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/stuff.txt":   `stuff`,
+		"/clean/stale": `stale`,
+	})
+	defer tmp.Remove()
+
+	mem := NewMemOutput()
+	mem.WriteFile("stale.txt", []byte("stale"))
+
+	_, err := Crawl(
+		outputHandler(tmp),
+		Output(tmp.Path("/public")),
+		OutputTo(uncomparableOutput{MemOutput: mem}),
+		FingerprintCache(""),
+		CleanDirs(tmp.Path("/clean")))
+	c.Must.Nil(err)
+
+	c.Equal(mem.Names(), []string{
+		"all.css",
+		"dir/stuff.txt",
+		"index.html",
+	})
+
+	_, err = os.Stat(tmp.Path("/clean/stale"))
+	c.True(os.IsNotExist(err))
+}
@@ -2,14 +2,13 @@ package crawl
 
 import (
 	"bytes"
-	"io/ioutil"
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
-	"path/filepath"
-	"strings"
-	"sync"
+	"strconv"
+	"time"
 )
 
 // A Page is a single page in a Site
@@ -17,11 +16,32 @@ type Page struct {
 	OrigURL     url.URL // URL without any changes
 	URL         url.URL // Final URL
 	Redirect    *Page   // Where this page redirected to
+	AliasOf     *Page   // Page that owns this page's output, if any
 	OutputPath  string  // Absolute path of output file
 	Fingerprint string  // Hash of content after all transforms
+	MediaType   string  // Media type of the response
+	Status      int     // HTTP status of the response
+	Size        int64   // Size of the output file, in bytes
 	cr          *crawler
-	pending     bool           // If load is in-progress
-	wg          sync.WaitGroup // For waiting for load to finish
+	lastMod     time.Time       // From the response's Last-Modified
+	integrity   string          // Subresource Integrity hash of the output
+	pending     bool            // If load is in-progress; guarded by cr.deps
+	rendering   bool            // If output isn't final; guarded by cr.deps
+	loaded      chan struct{}   // Closed when URL and output path are known
+	done        chan struct{}   // Closed when output is final
+	hasSlot     bool            // If holding one of cr's concurrency slots
+	errStatus   int             // Expected status, if this is an error page
+	errPath     string          // Where to write this error page
+	etag        string          // From the response's ETag
+	variant     string          // From the response's Variant
+	headers     http.Header     // Response headers to export; see Headers
+	served      bool            // If the output came from a handler response
+	links       []*resolvedLink // Everything linked to; only if Incremental
+	prev        *statePage      // Last crawl's output, if it might be reused
+	changed     bool            // If the output file was (re)written
+
+	// If the URL was changed to the canonical one for the Layout
+	canonicalized bool
 }
 
 // UserAgent is the agent sent with every crawler request
@@ -34,64 +54,249 @@ func newPage(cr *crawler, u *url.URL) *Page {
 		cr:      cr,
 	}
 
+	pg.init()
+	return pg
+}
+
+func newErrorPage(cr *crawler, u *url.URL, status int, path string) *Page {
+	pg := &Page{
+		OrigURL:   *u,
+		URL:       *u,
+		cr:        cr,
+		errStatus: status,
+		errPath:   path,
+	}
+
+	pg.init()
+	return pg
+}
+
+// newExcludedPage creates a Page for a URL that isn't crawled. It's never
+// loaded, and it isn't part of the Site: it only exists to be pointed at.
+func newExcludedPage(cr *crawler, u *url.URL) *Page {
+	uu := normURL(u)
+	return &Page{
+		OrigURL: *uu,
+		URL:     *uu,
+		cr:      cr,
+	}
+}
+
+// init readies a new page to be loaded. Pages are created with cr.mtx held,
+// so anything that might block (like event listeners) waits for start.
+func (pg *Page) init() {
 	pg.pending = !pg.IsExternal()
 
 	if pg.pending {
+		pg.rendering = true
+		pg.loaded = make(chan struct{})
+		pg.done = make(chan struct{})
 		pg.cr.wg.Add(1)
-		pg.wg.Add(1)
-		go pg.load()
 	}
+}
 
-	return pg
+// start starts loading a new page. cr.mtx must not be held.
+func (pg *Page) start() {
+	pg.cr.events.emit(Event{Kind: EventDiscovered, Page: pg})
+
+	if pg.pending {
+		go pg.load()
+	}
 }
 
 func (pg *Page) setLoaded() {
-	if pg.pending {
-		pg.pending = false
-		pg.wg.Done()
+	if pg.cr.deps.setLoaded(pg) {
+		close(pg.loaded)
 	}
 }
 
-func (pg *Page) waitLoaded() {
-	// Unfortunately, this can lead to deadlock if 2+ Contents rely on each
-	// other and haven't finished loading. It's quite complex to avoid this
-	// (you'd need a full dependency graph since you can have long loops like "a
-	// -> b -> c -> d -> a"), so rather than try to put something in that only
-	// works in a few, limited cases and gives a false sense of security, let's
-	// just consistently deadlock if it comes up.
-	//
-	// All things considered, this should be quite rare.
-	pg.wg.Wait()
+func (pg *Page) setDone() {
+	if pg.cr.deps.setDone(pg) {
+		close(pg.done)
+	}
+}
+
+// waitFor blocks until dep has reached the given state. If waiting would
+// deadlock because dep (transitively) depends on pg, a DependencyCycleError is
+// returned instead.
+func (pg *Page) waitFor(dep *Page, kind waitKind) error {
+	blocked, err := pg.cr.deps.add(pg, dep, kind)
+	if err != nil || !blocked {
+		return err
+	}
+
+	ch := dep.loaded
+	if kind == waitDone {
+		ch = dep.done
+	}
+
+	defer pg.cr.deps.remove(pg)
+
+	// Give up the concurrency slot while blocked: the page being waited on
+	// might not have been able to start yet.
+	pg.releaseSlot()
+
+	select {
+	case <-ch:
+	case <-pg.cr.ctx.Done():
+		return pg.cr.ctx.Err()
+	}
+
+	return pg.acquireSlot()
+}
+
+func (pg *Page) acquireSlot() error {
+	// Once cancelled, nothing else may start, even if a slot is free
+	err := pg.cr.ctx.Err()
+	if err != nil || pg.cr.slots == nil {
+		return err
+	}
+
+	select {
+	case pg.cr.slots <- struct{}{}:
+		pg.hasSlot = true
+		return nil
+
+	case <-pg.cr.ctx.Done():
+		return pg.cr.ctx.Err()
+	}
+}
+
+func (pg *Page) releaseSlot() {
+	if pg.hasSlot {
+		pg.hasSlot = false
+		<-pg.cr.slots
+	}
 }
 
 func (pg *Page) addError(err error) {
 	pg.cr.addError(pg.OrigURL, err)
 }
 
+func (pg *Page) addWarning(err error) {
+	pg.cr.addWarning(pg.OrigURL, err)
+}
+
 func (pg *Page) load() {
 	defer pg.cr.wg.Done()
+	defer pg.setDone()
 	defer pg.setLoaded()
+	defer pg.releaseSlot()
+
+	err := pg.acquireSlot()
+	if err == nil {
+		var rec *responseRecorder
+
+		pg.prev = pg.cr.state.get(pg)
+
+		pg.cr.events.emit(Event{Kind: EventRequestStart, Page: pg})
+		start := time.Now()
+
+		rec, err = pg.serve(pg.prev != nil)
+
+		ev := Event{
+			Kind:     EventRequestDone,
+			Page:     pg,
+			Duration: time.Since(start),
+			Err:      err,
+		}
+		if rec != nil {
+			ev.Status = rec.code
+		}
+
+		pg.cr.events.emit(ev)
+
+		if err == nil {
+			err = pg.handleResp(rec)
+		}
+	}
+
+	if err != nil {
+		pg.addError(err)
+	}
+}
+
+// serve runs the handler for this page. If the crawl is cancelled or the
+// handler runs longer than the page timeout, this returns without waiting for
+// the handler to finish: the handler is left to notice its request's context
+// has been cancelled.
+//
+// If conditional, the request is sent with the validators from the last
+// crawl.
+func (pg *Page) serve(conditional bool) (*responseRecorder, error) {
+	ctx := pg.cr.ctx
+	if pg.cr.pageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pg.cr.pageTimeout)
+		defer cancel()
+	}
 
 	req := httptest.NewRequest("GET", pg.OrigURL.String(), nil)
+	req = req.WithContext(ctx)
 	req.Header.Set("Accept", pathContentType+",*/*")
 	req.Header.Set("User-Agent", UserAgent)
 
-	rr := httptest.NewRecorder()
+	if pg.errStatus != 0 {
+		req.Header.Set(errorPageHeader, strconv.Itoa(pg.errStatus))
+	}
 
-	pg.cr.handler.ServeHTTP(rr, req)
+	if conditional {
+		pg.prev.conditional(req)
+	}
 
-	err := pg.handleResp(rr)
-	if err != nil {
-		pg.addError(err)
+	rec := newResponseRecorder(pg.cr)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		pg.cr.handler.ServeHTTP(rec, req)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	// Even if the handler finished, if the context was cancelled, then its
+	// response can't be trusted.
+	if err := pg.cr.ctx.Err(); err != nil {
+		rec.discard()
+		return nil, err
+	}
+
+	// The deadline might have passed just after the handler finished, which
+	// isn't a timeout
+	select {
+	case <-done:
+		return rec, nil
+
+	default:
+		rec.discard()
+		return nil, PageTimeoutError{Timeout: pg.cr.pageTimeout}
 	}
 }
 
-func (pg *Page) handleResp(rr *httptest.ResponseRecorder) error {
-	resp, err := newResponse(rr)
+func (pg *Page) handleResp(rec *responseRecorder) error {
+	resp, err := newResponse(rec)
 	if err != nil {
 		return err
 	}
 
+	defer resp.body.close()
+
+	pg.Status = resp.status
+
+	if resp.status == http.StatusNotModified && pg.prev != nil {
+		return pg.reuse(resp)
+	}
+
+	// Anything else means the last crawl's output is out of date
+	pg.prev = nil
+
+	if pg.errStatus != 0 {
+		return pg.renderErrorPage(resp)
+	}
+
 	switch resp.status {
 	case http.StatusOK:
 		return pg.render(resp)
@@ -104,6 +309,15 @@ func (pg *Page) handleResp(rr *httptest.ResponseRecorder) error {
 			return err
 		}
 
+		if !pg.cr.crawlable(redirURL) {
+			if pg.cr.filter.warn {
+				pg.addWarning(ExcludedLinkError(redirURL.String()))
+			}
+
+			pg.Redirect = newExcludedPage(pg.cr, redirURL)
+			return nil
+		}
+
 		pg.Redirect = pg.cr.get(redirURL)
 		return nil
 
@@ -116,10 +330,133 @@ func (pg *Page) handleResp(rr *httptest.ResponseRecorder) error {
 	}
 }
 
+func (pg *Page) renderErrorPage(resp *response) error {
+	if resp.status != pg.errStatus {
+		return ErrorPageStatusError{
+			Expected: pg.errStatus,
+			Got:      resp.status,
+		}
+	}
+
+	pg.URL.Path = pg.errPath
+	return pg.render(resp)
+}
+
+// reuse renders the output from the last crawl after the handler responded
+// with 304 Not Modified
+func (pg *Page) reuse(notMod *response) error {
+	prev := pg.prev
+
+	b, err := pg.cr.writer.readFile(prev.Output)
+	if err != nil {
+		return err
+	}
+
+	resp := &response{
+		status: http.StatusOK,
+		header: make(http.Header),
+	}
+
+	for k, vs := range prev.Headers {
+		resp.header[k] = vs
+	}
+
+	resp.body.mediaType = prev.MediaType
+	resp.body.set(b)
+
+	// A 304 is supposed to send the same validators a 200 would have, but
+	// don't count on it
+	etag := notMod.header.Get("ETag")
+	if etag == "" {
+		etag = prev.ETag
+	}
+
+	for k, v := range map[string]string{
+		"ETag":          etag,
+		"Last-Modified": prev.LastModified,
+		variantHeader:   prev.Variant,
+	} {
+		if v != "" {
+			resp.header.Set(k, v)
+		}
+	}
+
+	pg.Status = http.StatusOK
+	return pg.render(resp)
+}
+
+// revalidate checks that output reused from the last crawl is still good. The
+// handler said the page itself didn't change, but anything it links to might
+// have. If any link resolves differently than it did, the page is requested
+// again, without the conditional headers, and transformed from scratch.
+func (pg *Page) revalidate(resp *response) (*response, error) {
+	lr := newLinkResolver(pg)
+
+	stale := false
+	for _, link := range pg.prev.Links {
+		rl := lr.ResolveLink(link.URL)
+		if rl.Get() != link.Result {
+			stale = true
+			break
+		}
+
+		if link.Integrity != "" {
+			target, ok := rl.Target()
+			if !ok || target.Integrity != link.Integrity {
+				stale = true
+				break
+			}
+		}
+	}
+
+	if !stale {
+		return resp, nil
+	}
+
+	pg.links = nil
+
+	rec, err := pg.serve(false)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = newResponse(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.status != http.StatusOK {
+		body, _ := resp.body.get()
+		return nil, ResponseError{
+			Status: resp.status,
+			Body:   bytes.TrimSpace(body),
+		}
+	}
+
+	pg.headers = pg.cr.exportHeaders(resp.header)
+	pg.setValidators(resp)
+	return resp, pg.applyTransforms(resp)
+}
+
+func (pg *Page) setValidators(resp *response) {
+	pg.etag = resp.header.Get("ETag")
+
+	lastMod := resp.header.Get("Last-Modified")
+	if lastMod != "" {
+		// Only used for metadata, so a bad header isn't worth failing over
+		pg.lastMod, _ = http.ParseTime(lastMod)
+	}
+}
+
 func (pg *Page) render(resp *response) error {
-	variant := resp.header.Get(variantHeader)
-	if variant != "" {
-		u, err := pg.URL.Parse(variant)
+	pg.MediaType = resp.body.mediaType
+	pg.headers = pg.cr.exportHeaders(resp.header)
+	pg.served = true
+	pg.setValidators(resp)
+
+	pg.variant = resp.header.Get(variantHeader)
+	if pg.variant != "" {
+		u, err := pg.URL.Parse(pg.variant)
 		if err != nil {
 			return err
 		}
@@ -127,11 +464,19 @@ func (pg *Page) render(resp *response) error {
 		pg.URL = *u
 	}
 
-	// Need to claim after any variant changes so that variant paths won't
-	// collide
+	// Error pages are written exactly where they were asked to be
+	if pg.errStatus == 0 {
+		canon := pg.cr.layout.canonical(pg.URL.Path, pg.MediaType)
+		if canon != pg.URL.Path {
+			pg.URL.Path = canon
+			pg.canonicalized = pg.variant == ""
+		}
+	}
+
+	// Need to claim after any variant and layout changes so that those paths
+	// won't collide
 	if claimer, ok := pg.cr.claimPage(pg, pg.URL.Path); !ok {
-		pg.setAliasOf(claimer)
-		return nil
+		return pg.setAliasOf(claimer)
 	}
 
 	needsFingerprint := pg.cr.shouldFingerprint(pg.URL, resp.body.mediaType)
@@ -139,7 +484,16 @@ func (pg *Page) render(resp *response) error {
 		pg.setOutputPath()
 	}
 
-	err := pg.applyTransforms(resp)
+	var err error
+	if pg.prev != nil {
+		resp, err = pg.revalidate(resp)
+		if resp != nil {
+			defer resp.body.close()
+		}
+	} else {
+		err = pg.applyTransforms(resp)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -147,7 +501,7 @@ func (pg *Page) render(resp *response) error {
 	// Fingerprint after transforms so that any sub-resources with changed
 	// fingerprints change this resource's fingerprint.
 	if needsFingerprint {
-		fp, err := pg.cr.fingerprints.get(resp)
+		fp, err := pg.cr.fingerprints.get(&pg.OrigURL, resp)
 		if err != nil {
 			return err
 		}
@@ -156,10 +510,17 @@ func (pg *Page) render(resp *response) error {
 		pg.setOutputPath()
 	}
 
+	if pg.cr.integrity {
+		pg.integrity, err = integrity(&resp.body)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = checkServeMime(pg.OutputPath, resp.body.mediaType)
 	if err != nil {
 		// This is just advisory, so no need to fail hard
-		pg.addError(err)
+		pg.addWarning(err)
 	}
 
 	// Need to be sure that output file and all dirs in between are safe for use
@@ -168,57 +529,89 @@ func (pg *Page) render(resp *response) error {
 		return err
 	}
 
-	if resp.body.canSymlink() {
-		// Need to mark the src as used so that it doesn't get cleaned up,
-		// leaving a broken symlink.
-		pg.cr.setUsed(resp.body.symSrc)
+	switch {
+	case resp.body.canSymlink():
+		err = pg.symlink(resp.body.symSrc)
 
-		err := filePrepWrite(pg.OutputPath)
-		if err != nil {
-			return err
-		}
+	case resp.body.spill != nil:
+		err = pg.moveFile(resp.body.spill)
 
-		return os.Symlink(absPath(resp.body.symSrc), pg.OutputPath)
+	default:
+		err = pg.writeFile(resp.body.b)
 	}
 
-	// If the file hasn't changed, don't write anything: this is mainly for
-	// rsync.
-	equal, err := fileEquals(pg.OutputPath, resp.body.b)
-	if err != nil || equal {
+	if err != nil {
 		return err
 	}
 
-	err = filePrepWrite(pg.OutputPath)
+	return pg.cr.precompress.apply(pg, &resp.body)
+}
+
+func (pg *Page) symlink(src string) error {
+	// Need to mark the src as used so that it doesn't get cleaned up, leaving
+	// a broken symlink.
+	pg.cr.setUsed(src)
+
+	info, err := os.Stat(src)
 	if err != nil {
 		return err
 	}
 
-	return ioutil.WriteFile(pg.OutputPath, resp.body.b, 0666)
+	pg.Size = info.Size()
+
+	// What's linked to may have changed, so a symlink always counts as changed
+	pg.changed = true
+	return pg.cr.writer.symlink(src, pg.OutputPath)
 }
 
-func (pg *Page) setAliasOf(o *Page) {
-	o.waitLoaded()
-	pg.OutputPath = o.OutputPath
-	pg.Fingerprint = o.Fingerprint
+func (pg *Page) writeFile(b []byte) (err error) {
+	pg.Size = int64(len(b))
+	pg.changed, err = pg.cr.writer.updateFile(pg.OutputPath, b)
+	return
 }
 
-func (pg *Page) setOutputPath() {
-	outPath := pg.URL.Path
+// moveFile moves a spilled body into place. Once moved, the output file is
+// what holds the body.
+func (pg *Page) moveFile(sp *spilledBody) error {
+	pg.Size = sp.size
+
+	moved, changed, err := pg.cr.writer.moveFile(sp.path, pg.OutputPath)
+	pg.changed = changed
+	if err != nil {
+		return err
+	}
 
-	// If going to a directory, need to add an index.html
-	if strings.HasSuffix(outPath, "/") {
-		outPath += "index.html"
+	if moved {
+		sp.path = pg.OutputPath
+		sp.tmp = false
 	}
 
-	if pg.Fingerprint != "" {
-		outPath = addFingerprint(outPath, pg.Fingerprint)
+	return nil
+}
+
+func (pg *Page) setAliasOf(o *Page) error {
+	err := pg.waitFor(o, waitLoaded)
+	if err != nil {
+		return err
+	}
+
+	pg.AliasOf = o
+	pg.OutputPath = o.OutputPath
+	pg.Fingerprint = o.Fingerprint
 
-		// A fingerprint modifies the dest path, so need to reflect that back in
-		// the URL so that everything can be rewritten correctly
-		pg.URL.Path = outPath
+	pg.cr.events.emit(Event{Kind: EventAlias, Page: pg})
+	return nil
+}
+
+func (pg *Page) setOutputPath() {
+	if pg.Fingerprint != "" {
+		// A fingerprint modifies the URL (and maybe the dest path), so need to
+		// reflect that back in the URL so that everything can be rewritten
+		// correctly
+		pg.cr.fingerprints.addTo(&pg.URL, pg.Fingerprint)
 	}
 
-	pg.OutputPath = absPath(filepath.Join(pg.cr.output, outPath))
+	pg.OutputPath = pg.cr.outputPath(pg.cr.layout.file(pg.URL.Path, pg.MediaType))
 	pg.setLoaded()
 }
 
@@ -233,13 +626,21 @@ func (pg *Page) applyTransforms(resp *response) error {
 		return err
 	}
 
-	lr := (*linkResolver)(pg)
+	lr := newLinkResolver(pg)
 
 	for _, transform := range transforms {
+		start := time.Now()
+
 		b, err = transform(lr, b)
 		if err != nil {
 			return err
 		}
+
+		pg.cr.events.emit(Event{
+			Kind:     EventTransform,
+			Page:     pg,
+			Duration: time.Since(start),
+		})
 	}
 
 	resp.body.set(b)
@@ -253,9 +654,16 @@ func (pg *Page) IsExternal() bool {
 
 const maxRedirects = 25
 
-func (pg *Page) followRedirects() (*Page, error) {
+// followRedirects follows every redirect to the final Page, waiting for each
+// Page along the way to load on behalf of from. If from is nil, nothing is
+// waited for, so every Page must already be loaded.
+func (pg *Page) followRedirects(from *Page) (*Page, error) {
 	curr := pg
-	curr.waitLoaded()
+
+	err := from.waitForRedirect(curr)
+	if err != nil {
+		return nil, err
+	}
 
 	seen := make(map[*Page]struct{})
 	for curr.Redirect != nil {
@@ -275,17 +683,29 @@ func (pg *Page) followRedirects() (*Page, error) {
 		}
 
 		curr = curr.Redirect
-		curr.waitLoaded()
+
+		err := from.waitForRedirect(curr)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return curr, nil
 }
 
+func (pg *Page) waitForRedirect(dep *Page) error {
+	if pg == nil {
+		return nil
+	}
+
+	return pg.waitFor(dep, waitLoaded)
+}
+
 // FollowRedirects follows every redirect to the final Page
 func (pg *Page) FollowRedirects() *Page {
-	// There's no need to do any checks here as in followRedirects(): it
-	// shouldn't be possible to access a page externally if there's an error
-	// during Crawl().
+	// There's no need to do any checks here as in followRedirects(): Crawl()
+	// fails if there are any loops (see checkRedirects), and it shouldn't be
+	// possible to access a page externally if there's an error.
 	for pg.Redirect != nil {
 		pg = pg.Redirect
 	}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -468,6 +469,7 @@ func TestPageErrors(t *testing.T) {
 			}),
 			opts: []Option{
 				Entry(&url.URL{Path: "/page.html"}),
+				StrictWarnings(),
 			},
 			err: SiteError{
 				"/page.html": {
@@ -488,6 +490,7 @@ func TestPageErrors(t *testing.T) {
 			}),
 			opts: []Option{
 				Entry(&url.URL{Path: "/page.not-an-ext"}),
+				StrictWarnings(),
 			},
 			err: SiteError{
 				"/page.not-an-ext": {
@@ -662,3 +665,261 @@ func TestPageErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestPageWarnings(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/page.html"></a>`,
+			},
+			"/page.html": stringHandler{
+				contType: testutil.GifType,
+				body:     string(testutil.GifBin),
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Transforms(map[string][]Transform{
+			htmlType: {func(lr LinkResolver, b []byte) ([]byte, error) {
+				lr.Warn(errors.New("transform warning"))
+				return b, nil
+			}},
+		}))
+	c.Must.Nil(err)
+
+	c.Equal(site.Warnings(), SiteError{
+		"/": {
+			errors.New("transform warning"),
+		},
+		"/page.html": {
+			MimeTypeMismatchError{
+				Ext:          ".html",
+				Guess:        htmlType,
+				FromResponse: testutil.GifType,
+			},
+		},
+	})
+
+	c.Equal(tmp.ReadFile("/public/page.html"), string(testutil.GifBin))
+}
+
+func TestPageNoWarnings(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `index`,
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.Nil(err)
+	c.True(site.Warnings() == nil)
+}
+
+func TestPageErrorPages(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := stringHandler{
+				contType: htmlType,
+				body:     `index`,
+			}
+
+			switch {
+			case r.URL.Path == "/":
+
+			case r.URL.Path == "/img.gif":
+				h.contType = testutil.GifType
+				h.body = string(testutil.GifBin)
+
+			case ErrorPageStatus(r) == http.StatusGone:
+				w.Header().Set("Content-Type", htmlType)
+				w.WriteHeader(http.StatusGone)
+				io.WriteString(w, `gone`)
+				return
+
+			default:
+				w.Header().Set("Content-Type", htmlType)
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, `<img src="img.gif"> not found`)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		}),
+		ErrorPage(http.StatusNotFound, "/404.html"),
+		ErrorPage(http.StatusGone, "/errors/410.html"),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	c.Contains(tmp.ReadFile("/public/404.html"), `not found`)
+	c.Equal(tmp.ReadFile("/public/errors/410.html"), `gone`)
+	c.Equal(tmp.ReadFile("/public/img.gif"), string(testutil.GifBin))
+
+	notFound := site.GetErrorPage(http.StatusNotFound)
+	c.Must.NotNil(notFound)
+	c.Equal(notFound.Status, http.StatusNotFound)
+	c.Equal(notFound.URL.Path, "/404.html")
+	c.Equal(notFound.OutputPath, tmp.Path("/public/404.html"))
+
+	pgs := site.ErrorPages()
+	c.Must.Len(pgs, 2)
+	c.Equal(pgs[0], notFound)
+	c.Equal(pgs[1].Status, http.StatusGone)
+}
+
+func TestPageErrorPageUnexpectedStatus(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(
+		stringHandler{
+			contType: htmlType,
+			body:     `catch all`,
+		},
+		ErrorPage(http.StatusNotFound, "/404.html"),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+
+	c.Equal(err, SiteError{
+		fmt.Sprintf(errorPageURL, http.StatusNotFound): {
+			ErrorPageStatusError{
+				Expected: http.StatusNotFound,
+				Got:      http.StatusOK,
+			},
+		},
+	})
+}
+
+func TestPageFingerprintModules(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	jsContType := mime.TypeByExtension(".js")
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<script type="module" src="/js/main.js"></script>`,
+			},
+			"/js/main.js": stringHandler{
+				contType: jsContType,
+				body:     `import { dep } from "./dep.js"; dep();`,
+			},
+			"/js/dep.js": stringHandler{
+				contType: jsContType,
+				body:     `export function dep() {}`,
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Fingerprint(func(u *url.URL, mediaType string) bool {
+			return filepath.Ext(u.Path) == ".js"
+		}))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	main := site.GetPage("/js/main.js")
+	dep := site.GetPage("/js/dep.js")
+
+	c.Contains(tmp.ReadFile("/public/index.html"), main.URL.Path)
+	c.Contains(
+		tmp.ReadFile(filepath.Join("/public", main.URL.Path)),
+		dep.URL.Path)
+}
+
+func TestPageBaseHref(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body: `<html><head><base href="docs/"></head>` +
+					`<body><a href="page.html"></a>` +
+					`<img src="img.gif" srcset="img.gif 2x">` +
+					`<div style="background: url(img.gif)"></div>` +
+					`</body></html>`,
+			},
+			"/docs/page.html": stringHandler{
+				contType: htmlType,
+				body:     `page`,
+			},
+			"/docs/img.gif": stringHandler{
+				contType: testutil.GifType,
+				body:     string(testutil.GifBin),
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Fingerprint(func(u *url.URL, mediaType string) bool {
+			return mediaType == testutil.GifType
+		}))
+	c.Must.Nil(err)
+
+	img := site.GetPage("/docs/img.gif")
+	c.Must.NotNil(img)
+	c.Must.NotNil(site.GetPage("/docs/page.html"))
+
+	// The base is only used to resolve links: it isn't crawled itself
+	c.True(site.Get(&url.URL{Path: "/docs/"}) == nil)
+
+	index := tmp.ReadFile("/public/index.html")
+	c.Contains(index, `<base href=docs/>`)
+	c.Contains(index, `<a href=/docs/page.html>`)
+	c.Contains(index, path.Base(img.URL.Path))
+	c.NotContains(index, "img.gif")
+}
+
+func TestPageBaseHrefCrawled(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	// A base that's part of the crawl is rewritten like any other link
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/docs">`,
+			},
+			"/docs": stringHandler{
+				contType: htmlType,
+				body: `<html><head><base href="/docs"></head>` +
+					`<body><a href="/docs">docs</a></body></html>`,
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		OutputLayout(LayoutDirectory))
+	c.Must.Nil(err)
+
+	c.Contains(tmp.ReadFile("/public/docs/index.html"), `<base href=/docs/>`)
+}
@@ -0,0 +1,165 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/andybalholm/brotli"
+)
+
+// A Compressor produces a precompressed copy of an output file
+type Compressor struct {
+	Ext string // Appended to the output file's name (eg. ".gz")
+	New func(w io.Writer) (io.WriteCloser, error)
+}
+
+// Gzip compresses files at the best compression level to .gz
+func Gzip() Compressor {
+	return Compressor{
+		Ext: ".gz",
+		New: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, gzip.BestCompression)
+		},
+	}
+}
+
+// Brotli compresses files at the best compression level to .br
+func Brotli() Compressor {
+	return Compressor{
+		Ext: ".br",
+		New: func(w io.Writer) (io.WriteCloser, error) {
+			return brotli.NewWriterLevel(w, brotli.BestCompression), nil
+		},
+	}
+}
+
+type precompressor struct {
+	comps      []Compressor
+	minSize    int64
+	mediaTypes map[string]struct{}
+}
+
+func (pc *precompressor) should(body *responseBody) (bool, error) {
+	if len(pc.comps) == 0 {
+		return false, nil
+	}
+
+	if len(pc.mediaTypes) > 0 {
+		if _, ok := pc.mediaTypes[body.mediaType]; !ok {
+			return false, nil
+		}
+	}
+
+	size, err := body.size()
+	if err != nil {
+		return false, err
+	}
+
+	return size >= pc.minSize, nil
+}
+
+func (pc *precompressor) apply(pg *Page, body *responseBody) error {
+	should, err := pc.should(body)
+	if err != nil || !should {
+		return err
+	}
+
+	for _, comp := range pc.comps {
+		path := pg.OutputPath + comp.Ext
+
+		err := pg.cr.claimFile(pg, path)
+		if err != nil {
+			return err
+		}
+
+		// Compression is deterministic, so if the output didn't change and it
+		// was already compressed, there's no need to do it again
+		if !pg.changed {
+			kept, err := pg.cr.writer.keepFile(path)
+			if err != nil {
+				return err
+			}
+
+			if kept {
+				continue
+			}
+		}
+
+		if body.spill != nil {
+			err = compressSpilled(pg, comp, body, path)
+		} else {
+			err = compressBuffered(pg, comp, body, path)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func compressBuffered(pg *Page, comp Compressor, body *responseBody,
+	path string) error {
+
+	var buff bytes.Buffer
+
+	err := compress(comp, body, &buff)
+	if err != nil {
+		return err
+	}
+
+	return pg.cr.writer.writeFile(path, buff.Bytes())
+}
+
+// compressSpilled compresses a body that was too big for memory to a spill
+// file, and moves it into place just like the body itself
+func compressSpilled(pg *Page, comp Compressor, body *responseBody,
+	path string) error {
+
+	f, err := pg.cr.spill.tempFile()
+	if err != nil {
+		return err
+	}
+
+	err = compress(comp, body, f)
+
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	moved := false
+	if err == nil {
+		moved, _, err = pg.cr.writer.moveFile(f.Name(), path)
+	}
+
+	if !moved {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+func compress(comp Compressor, body *responseBody, dst io.Writer) error {
+	r, err := body.reader()
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := comp.New(dst)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
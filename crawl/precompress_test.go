@@ -0,0 +1,208 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func gunzipFile(c *check.C, path string) string {
+	b, err := ioutil.ReadFile(path)
+	c.Must.Nil(err)
+
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	c.Must.Nil(err)
+
+	ub, err := ioutil.ReadAll(gz)
+	c.Must.Nil(err)
+
+	return string(ub)
+}
+
+func unbrotliFile(c *check.C, path string) string {
+	b, err := ioutil.ReadFile(path)
+	c.Must.Nil(err)
+
+	ub, err := ioutil.ReadAll(brotli.NewReader(bytes.NewReader(b)))
+	c.Must.Nil(err)
+
+	return string(ub)
+}
+
+func TestPrecompressBasic(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/stuff.txt": `some stuff`,
+	})
+	defer tmp.Remove()
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body: `` +
+					`<a href="/stuff.txt">stuff</a>` +
+					`<img src="/img.gif">` +
+					`<link href="/tiny.css" rel="stylesheet">`,
+			},
+			"/stuff.txt": http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					ServeFile(w, r, tmp.Path("/stuff.txt"))
+				}),
+			"/img.gif": stringHandler{
+				contType: testutil.GifType,
+				body:     string(testutil.GifBin),
+			},
+			"/tiny.css": stringHandler{
+				contType: cssType,
+				body:     `a{}`,
+			},
+		}),
+		Precompress(
+			[]Compressor{Gzip(), Brotli()},
+			10,
+			[]string{htmlType, cssType, "text/plain"}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	c.Equal(
+		gunzipFile(c, tmp.Path("/public/index.html.gz")),
+		tmp.ReadFile("/public/index.html"))
+	c.Equal(
+		gunzipFile(c, tmp.Path("/public/stuff.txt.gz")),
+		`some stuff`)
+	c.Equal(
+		unbrotliFile(c, tmp.Path("/public/index.html.br")),
+		tmp.ReadFile("/public/index.html"))
+
+	// Wrong media type
+	_, err = os.Stat(tmp.Path("/public/img.gif.gz"))
+	c.True(os.IsNotExist(err))
+
+	// Too small
+	_, err = os.Stat(tmp.Path("/public/tiny.css.gz"))
+	c.True(os.IsNotExist(err))
+}
+
+func TestPrecompressUnchanged(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	body := `index`
+	crawl := func() {
+		_, err := Crawl(
+			mux(map[string]http.Handler{
+				"/": http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						stringHandler{
+							contType: htmlType,
+							body:     body,
+						}.ServeHTTP(w, r)
+					}),
+			}),
+			Entry(&url.URL{Path: "/"}),
+			Precompress([]Compressor{Gzip()}, 0, nil),
+			Output(tmp.Path("/public")),
+			FingerprintCache(tmp.Path(".cache/fingerprints")))
+		c.Must.Nil(err)
+	}
+
+	crawl()
+
+	// An unchanged file is left alone, no matter its mod time
+	past := time.Now().Add(-time.Hour)
+	err := os.Chtimes(tmp.Path("/public/index.html.gz"), past, past)
+	c.Must.Nil(err)
+
+	crawl()
+
+	info, err := os.Stat(tmp.Path("/public/index.html.gz"))
+	c.Must.Nil(err)
+	c.True(info.ModTime().Equal(past))
+
+	// Nothing is compressed again until the output changes
+	tmp.WriteFile("/public/index.html.gz", "kept")
+	crawl()
+	c.Equal(tmp.ReadFile("/public/index.html.gz"), "kept")
+
+	// Unless the compressed file is gone
+	err = os.Remove(tmp.Path("/public/index.html.gz"))
+	c.Must.Nil(err)
+
+	crawl()
+	c.Equal(gunzipFile(c, tmp.Path("/public/index.html.gz")), `index`)
+
+	body = `changed`
+	crawl()
+	c.Equal(gunzipFile(c, tmp.Path("/public/index.html.gz")), `changed`)
+}
+
+func TestPrecompressSpill(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	big := strings.Repeat("0123456789", 1000)
+	tmp.WriteFile("/spill/.keep", "")
+
+	crawl := func() []string {
+		var (
+			mtx       sync.Mutex
+			unchanged []string
+		)
+
+		_, err := Crawl(
+			mux(map[string]http.Handler{
+				"/": chunkedHandler{
+					contType: DefaultType,
+					body:     []byte(big),
+				},
+			}),
+			Precompress([]Compressor{Gzip(), Brotli()}, 0, nil),
+			Output(tmp.Path("/public")),
+			FingerprintCache(""),
+			SpillThreshold(1024, tmp.Path("/spill")),
+			Events(func(ev Event) {
+				if ev.Kind == EventUnchanged {
+					mtx.Lock()
+					unchanged = append(unchanged, ev.Path)
+					mtx.Unlock()
+				}
+			}))
+		c.Must.Nil(err)
+
+		// Nothing is left behind
+		infos, err := ioutil.ReadDir(tmp.Path("/spill"))
+		c.Must.Nil(err)
+		c.Len(infos, 1)
+
+		return unchanged
+	}
+
+	crawl()
+	tmp.DumpTree()
+
+	c.Equal(gunzipFile(c, tmp.Path("/public/index.html.gz")), big)
+	c.Equal(unbrotliFile(c, tmp.Path("/public/index.html.br")), big)
+
+	unchanged := crawl()
+	c.Contains(unchanged, tmp.Path("/public/index.html.gz"))
+	c.Contains(unchanged, tmp.Path("/public/index.html.br"))
+}
@@ -0,0 +1,262 @@
+package crawl
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"math/rand"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// defaultSpillThreshold is how big a body can get before it's spilled to disk
+const defaultSpillThreshold = 8 << 20
+
+type spillConfig struct {
+	threshold int64 // <= 0 to never spill
+	dir       string
+}
+
+// tempFile creates a file to spill to. Spilled files are moved into the
+// output as-is, so unlike ioutil.TempFile (which is only readable by its
+// owner), it's created with the same mode as every other output file.
+func (sc spillConfig) tempFile() (*os.File, error) {
+	dir := sc.dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	for i := 0; i < 10000; i++ {
+		name := filepath.Join(dir,
+			"acrylic-spill-"+strconv.FormatUint(uint64(rand.Uint32()), 10))
+
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+
+	return nil, &os.PathError{
+		Op:   "open",
+		Path: filepath.Join(dir, "acrylic-spill-*"),
+		Err:  os.ErrExist,
+	}
+}
+
+var errRecorderClosed = errors.New("response already finished")
+
+// A responseRecorder is like httptest.ResponseRecorder, except that bodies
+// that won't be transformed are streamed: once they grow past the spill
+// threshold, they're moved out of memory into a temp file and hashed as they're
+// written, so they never have to be read back just to be hashed.
+type responseRecorder struct {
+	cr *crawler
+
+	mtx         sync.Mutex
+	code        int
+	header      http.Header
+	wroteHeader bool
+	stream      bool // If the body may be spilled
+	buf         bytes.Buffer
+	spill       *os.File // Once the body is past the threshold
+	size        int64
+	crc         hash.Hash32 // Only once spilled
+	hash        hash.Hash   // Only once spilled
+	err         error       // First error spilling the body
+	closed      bool
+}
+
+func newResponseRecorder(cr *crawler) *responseRecorder {
+	return &responseRecorder{
+		cr:     cr,
+		code:   http.StatusOK,
+		header: make(http.Header),
+	}
+}
+
+// Header implements http.ResponseWriter
+func (rec *responseRecorder) Header() http.Header {
+	return rec.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (rec *responseRecorder) WriteHeader(code int) {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+
+	rec.writeHeader(code)
+}
+
+func (rec *responseRecorder) writeHeader(code int) {
+	if rec.wroteHeader {
+		return
+	}
+
+	rec.code = code
+	rec.wroteHeader = true
+
+	rec.stream = rec.cr.canStream(code, rec.header.Get("Content-Type"))
+}
+
+// Write implements http.ResponseWriter
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+
+	// If the handler outlived its request, don't let it write anywhere
+	if rec.closed {
+		return 0, errRecorderClosed
+	}
+
+	if !rec.wroteHeader {
+		// Same as net/http: sniff the type if the handler didn't set one
+		_, hasType := rec.header["Content-Type"]
+		if !hasType && rec.header.Get("Transfer-Encoding") == "" {
+			rec.header.Set("Content-Type", http.DetectContentType(b))
+		}
+
+		rec.writeHeader(http.StatusOK)
+	}
+
+	if rec.err != nil {
+		return 0, rec.err
+	}
+
+	rec.size += int64(len(b))
+
+	if rec.spill == nil &&
+		rec.stream &&
+		int64(rec.buf.Len()+len(b)) > rec.cr.spill.threshold {
+
+		rec.err = rec.startSpill()
+		if rec.err != nil {
+			return 0, rec.err
+		}
+	}
+
+	if rec.spill == nil {
+		return rec.buf.Write(b)
+	}
+
+	rec.crc.Write(b)
+	rec.hash.Write(b)
+
+	n, err := rec.spill.Write(b)
+	if err != nil {
+		rec.err = err
+	}
+
+	return n, err
+}
+
+// Flush implements http.Flusher. Handlers that stream their responses tend to
+// flush, and there's nothing to do.
+func (rec *responseRecorder) Flush() {}
+
+func (rec *responseRecorder) startSpill() error {
+	f, err := rec.cr.spill.tempFile()
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(rec.buf.Bytes())
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	// Bodies that stay in memory are only hashed if they need to be, but a
+	// spilled body needs its hash to avoid reading it back
+	rec.crc = crc32.NewIEEE()
+	rec.crc.Write(rec.buf.Bytes())
+	rec.hash = rec.cr.fingerprints.newHash()
+	rec.hash.Write(rec.buf.Bytes())
+
+	rec.spill = f
+	rec.buf = bytes.Buffer{}
+	return nil
+}
+
+// finish stops the handler from writing anything else and gets the body, if it
+// was spilled
+func (rec *responseRecorder) finish() (*spilledBody, error) {
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+
+	rec.closed = true
+
+	if rec.spill == nil {
+		return nil, rec.err
+	}
+
+	f := rec.spill
+	rec.spill = nil
+
+	err := f.Close()
+	if err == nil {
+		err = rec.err
+	}
+
+	sp := &spilledBody{
+		path: f.Name(),
+		tmp:  true,
+		size: rec.size,
+		crc:  rec.crc.Sum32(),
+		sum:  hex.EncodeToString(rec.hash.Sum(nil)),
+	}
+
+	if err != nil {
+		sp.close()
+		return nil, err
+	}
+
+	return sp, nil
+}
+
+// discard throws away the response, eg. when the handler timed out
+func (rec *responseRecorder) discard() {
+	sp, _ := rec.finish()
+	if sp != nil {
+		sp.close()
+	}
+}
+
+// canStream determines if a response can be streamed. Anything that will be
+// transformed needs to be in memory anyway, and anything other than a 200 is
+// tiny.
+func (cr *crawler) canStream(status int, contType string) bool {
+	if cr.spill.threshold <= 0 || status != http.StatusOK {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contType)
+	if err != nil || mediaType == pathContentType {
+		return false
+	}
+
+	return len(cr.transforms[mediaType]) == 0
+}
+
+// A spilledBody is a body that was too big to keep in memory. It was hashed as
+// it streamed in, so it never has to be read back just to be fingerprinted.
+type spilledBody struct {
+	path string
+	tmp  bool // If path is a temp file that needs to be removed
+	size int64
+	crc  uint32
+	sum  string // Full fingerprint, from fingerprints.newHash
+}
+
+func (sp *spilledBody) close() {
+	if sp.tmp {
+		os.Remove(sp.path)
+		sp.tmp = false
+	}
+}
@@ -0,0 +1,256 @@
+package crawl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+type chunkedHandler struct {
+	contType string
+	body     []byte
+}
+
+func (h chunkedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", h.contType)
+
+	b := h.body
+	for len(b) > 0 {
+		n := 1000
+		if n > len(b) {
+			n = len(b)
+		}
+
+		w.Write(b[:n])
+		w.(http.Flusher).Flush()
+		b = b[n:]
+	}
+}
+
+func TestSpill(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	big := bytes.Repeat([]byte("0123456789"), 10000)
+	css := strings.Repeat("a { color: red; }\n", 1000)
+
+	handler := mux(map[string]http.Handler{
+		"/": stringHandler{
+			contType: htmlType,
+			body: `` +
+				`<a href="/big.bin">` +
+				`<a href="/small.bin">` +
+				`<link rel="stylesheet" href="/all.css">`,
+		},
+		"/big.bin": chunkedHandler{
+			contType: DefaultType,
+			body:     big,
+		},
+		"/small.bin": stringHandler{
+			contType: DefaultType,
+			body:     `small`,
+		},
+		"/all.css": chunkedHandler{
+			contType: "text/css",
+			body:     []byte(css),
+		},
+	})
+
+	tmp.WriteFile("/spill/.keep", "")
+
+	crawl := func() (Site, []string) {
+		var (
+			mtx       sync.Mutex
+			unchanged []string
+		)
+
+		site, err := Crawl(handler,
+			Output(tmp.Path("/public")),
+			FingerprintCache(""),
+			Fingerprint(func(u *url.URL, mediaType string) bool {
+				return mediaType == DefaultType
+			}),
+			SpillThreshold(1024, tmp.Path("/spill")),
+			Events(func(ev Event) {
+				if ev.Kind == EventUnchanged {
+					mtx.Lock()
+					unchanged = append(unchanged, ev.Path)
+					mtx.Unlock()
+				}
+			}))
+		c.Must.Nil(err)
+
+		// Nothing is left behind
+		infos, err := ioutil.ReadDir(tmp.Path("/spill"))
+		c.Must.Nil(err)
+		c.Len(infos, 1)
+
+		return site, unchanged
+	}
+
+	site, _ := crawl()
+	tmp.DumpTree()
+
+	fp, err := fingerprint(sha1.New, bytes.NewReader(big))
+	c.Must.Nil(err)
+
+	pg := site.GetPage("/big.bin")
+	c.Must.NotNil(pg)
+	c.Equal(pg.Fingerprint, fp)
+	c.Equal(pg.Size, int64(len(big)))
+	c.Equal(tmp.ReadFile("/public/big."+fp+".bin"), string(big))
+
+	// Spilled files get the same mode as written ones
+	bigInfo, err := os.Stat(tmp.Path("/public/big." + fp + ".bin"))
+	c.Must.Nil(err)
+	indexInfo, err := os.Stat(tmp.Path("/public/index.html"))
+	c.Must.Nil(err)
+	c.Equal(bigInfo.Mode(), indexInfo.Mode())
+
+	pg = site.GetPage("/small.bin")
+	c.Must.NotNil(pg)
+	c.Equal(tmp.ReadFile("/public/small."+pg.Fingerprint+".bin"), `small`)
+
+	// Bodies that are transformed are never spilled
+	c.Equal(
+		tmp.ReadFile("/public/all.css"),
+		strings.Repeat("a{color:red}", 1000))
+
+	_, unchanged := crawl()
+	c.Contains(unchanged, tmp.Path("/public/big."+fp+".bin"))
+}
+
+func TestSpillDryRun(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	handler := mux(map[string]http.Handler{
+		"/": chunkedHandler{
+			contType: DefaultType,
+			body:     bytes.Repeat([]byte("a"), 5000),
+		},
+	})
+
+	site, err := Crawl(handler,
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		SpillThreshold(1024, tmp.Path("/")),
+		DryRun())
+	c.Must.Nil(err)
+
+	c.Equal(
+		site.DryRunReport().Created,
+		[]string{tmp.Path("/public/index.html")})
+	c.Len(tmp.GetFiles(), 0)
+}
+
+// streamOutput is a MemOutput that records everything streamed to it
+type streamOutput struct {
+	*MemOutput
+	mtx      sync.Mutex
+	streamed []string
+}
+
+func (s *streamOutput) WriteFrom(name string, size int64, r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	if int64(len(b)) != size {
+		return io.ErrUnexpectedEOF
+	}
+
+	s.mtx.Lock()
+	s.streamed = append(s.streamed, name)
+	s.mtx.Unlock()
+
+	return s.WriteFile(name, b)
+}
+
+func TestSpillStream(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	big := bytes.Repeat([]byte("0123456789"), 1000)
+	out := &streamOutput{MemOutput: NewMemOutput()}
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/big.bin">`,
+			},
+			"/big.bin": chunkedHandler{
+				contType: DefaultType,
+				body:     big,
+			},
+		}),
+		Output(tmp.Path("/public")),
+		OutputTo(out),
+		FingerprintCache(""),
+		SpillThreshold(1024, tmp.Path("/")))
+	c.Must.Nil(err)
+
+	c.Equal(out.streamed, []string{"big.bin"})
+
+	b, err := out.ReadFile("big.bin")
+	c.Must.Nil(err)
+	c.Equal(b, big)
+
+	// The spilled body was copied, so it's still cleaned up
+	c.Len(tmp.GetFiles(), 0)
+}
+
+func TestRecorderHashOnlySpilled(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	cr := newCrawler(context.Background(), nil,
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		SpillThreshold(10, tmp.Path("/")))
+
+	rec := newResponseRecorder(cr)
+	rec.Header().Set("Content-Type", DefaultType)
+	rec.Write([]byte("small"))
+
+	sp, err := rec.finish()
+	c.Must.Nil(err)
+	c.True(sp == nil)
+	c.True(rec.hash == nil)
+
+	rec = newResponseRecorder(cr)
+	rec.Header().Set("Content-Type", DefaultType)
+	rec.Write([]byte("01234"))
+	rec.Write([]byte("56789"))
+	rec.Write([]byte("abcde"))
+
+	sp, err = rec.finish()
+	c.Must.Nil(err)
+	c.Must.NotNil(sp)
+	defer sp.close()
+
+	sum, err := fingerprint(sha1.New, strings.NewReader("0123456789abcde"))
+	c.Must.Nil(err)
+	c.Equal(sp.sum, sum)
+}
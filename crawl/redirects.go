@@ -0,0 +1,193 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// A RedirectMode determines how redirects are written out
+type RedirectMode int
+
+const (
+	// RedirectHTML writes an HTML page at the original path of every redirect
+	// (laid out like any other page; see OutputLayout) that sends browsers on
+	// with a meta refresh. Since static files have no
+	// way of setting a status code, every redirect is effectively temporary,
+	// so a canonical link is included to tell search engines where the page
+	// went.
+	RedirectHTML RedirectMode = iota + 1
+
+	// RedirectNetlify writes a Netlify-style _redirects file to the root of
+	// the output directory.
+	RedirectNetlify
+
+	// RedirectNginx writes an nginx include to the root of the output
+	// directory. It contains one map per status code that maps each original
+	// path to its target. Include it in the http block, and use it from a
+	// server block like so:
+	//
+	//	if ($acrylic_redirect_301) {
+	//		return 301 $acrylic_redirect_301;
+	//	}
+	RedirectNginx
+)
+
+const (
+	netlifyRedirectsPath = "/_redirects"
+	nginxRedirectsPath   = "/redirects.nginx.conf"
+)
+
+func genRedirects(cr *crawler, mode RedirectMode) {
+	var pgs []*Page
+	for _, pg := range cr.site.urls {
+		redirects := pg.Redirect != nil || pg.canonicalized
+		if redirects && !pg.IsExternal() && pg.OrigURL.RawQuery == "" {
+			pgs = append(pgs, pg)
+		}
+	}
+
+	sort.Slice(pgs, func(i, j int) bool {
+		return pgs[i].OrigURL.Path < pgs[j].OrigURL.Path
+	})
+
+	switch mode {
+	case RedirectHTML:
+		for _, pg := range pgs {
+			// Static servers already redirect directories to add the
+			// trailing slash, and the stub would be in the way
+			if pg.canonicalized && cr.layout == LayoutDirectory {
+				continue
+			}
+
+			err := pg.writeRedirectStub()
+			if err != nil {
+				pg.addError(err)
+			}
+		}
+
+	case RedirectNetlify:
+		genFile(cr, netlifyRedirectsPath, netlifyRedirects(pgs))
+
+	case RedirectNginx:
+		genFile(cr, nginxRedirectsPath, nginxRedirects(pgs))
+
+	default:
+		cr.addError(url.URL{}, fmt.Errorf("unknown redirect mode %d", mode))
+	}
+}
+
+func genFile(cr *crawler, path string, b []byte) {
+	pg, err := cr.genPage(path, DefaultType)
+	if err == nil {
+		err = pg.writeFile(b)
+	}
+
+	if err != nil {
+		cr.addError(url.URL{Path: path}, err)
+	}
+}
+
+func (pg *Page) redirectTarget() string {
+	return pg.FollowRedirects().URL.String()
+}
+
+// redirectStatus gets the status to redirect with. Pages that were moved to
+// their canonical URL are moved for good.
+func (pg *Page) redirectStatus() int {
+	if pg.Redirect == nil {
+		return http.StatusMovedPermanently
+	}
+
+	return pg.Status
+}
+
+// redirectStubPath gets where the redirect stub for pg is written. Stubs are
+// HTML pages, so they're laid out like any other, except for pages moved to
+// their canonical URL: laying those out would put the stub on top of the page.
+func (pg *Page) redirectStubPath() string {
+	urlPath := pg.OrigURL.Path
+	if !pg.canonicalized {
+		layout := pg.cr.layout
+		urlPath = layout.file(layout.canonical(urlPath, htmlType), htmlType)
+	}
+
+	return pg.cr.outputPath(urlPath)
+}
+
+func (pg *Page) writeRedirectStub() error {
+	path := pg.redirectStubPath()
+
+	err := pg.cr.claimFile(pg, path)
+	if err != nil {
+		return err
+	}
+
+	to := html.EscapeString(pg.redirectTarget())
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, ""+
+		"<!DOCTYPE html>\n"+
+		"<html>\n"+
+		"<head>\n"+
+		"<meta charset=\"utf-8\">\n"+
+		"<title>Redirecting&hellip;</title>\n"+
+		"<link rel=\"canonical\" href=\"%s\">\n"+
+		"<meta http-equiv=\"refresh\" content=\"0; url=%s\">\n"+
+		"<meta name=\"robots\" content=\"noindex\">\n"+
+		"</head>\n"+
+		"<body><a href=\"%s\">Redirecting&hellip;</a></body>\n"+
+		"</html>\n",
+		to, to, to)
+
+	// A canonicalized page already has its own output
+	if pg.Redirect == nil {
+		return pg.cr.writer.writeFile(path, b.Bytes())
+	}
+
+	pg.OutputPath = path
+	return pg.writeFile(b.Bytes())
+}
+
+func netlifyRedirects(pgs []*Page) []byte {
+	var b bytes.Buffer
+	for _, pg := range pgs {
+		fmt.Fprintf(&b, "%s %s %d\n",
+			pg.OrigURL.String(), pg.redirectTarget(), pg.redirectStatus())
+	}
+
+	return b.Bytes()
+}
+
+func nginxRedirects(pgs []*Page) []byte {
+	byStatus := make(map[int][]*Page)
+	for _, pg := range pgs {
+		status := pg.redirectStatus()
+		byStatus[status] = append(byStatus[status], pg)
+	}
+
+	var statuses []int
+	for status := range byStatus {
+		statuses = append(statuses, status)
+	}
+
+	sort.Ints(statuses)
+
+	var b bytes.Buffer
+	for _, status := range statuses {
+		fmt.Fprintf(&b, "map $uri $acrylic_redirect_%d {\n", status)
+
+		for _, pg := range byStatus[status] {
+			fmt.Fprintf(&b, "\t%s %s;\n",
+				nginxQuote(pg.OrigURL.Path),
+				nginxQuote(pg.redirectTarget()))
+		}
+
+		b.WriteString("}\n")
+	}
+
+	return b.Bytes()
+}
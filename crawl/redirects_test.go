@@ -0,0 +1,136 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+var redirectHandler = mux(map[string]http.Handler{
+	"/": stringHandler{
+		contType: htmlType,
+		body: `` +
+			`<a href="/moved/">moved</a>` +
+			`<a href="/temp/">temp</a>`,
+	},
+	"/moved/": http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/new/", http.StatusMovedPermanently)
+		}),
+	"/temp/": http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/moved/", http.StatusFound)
+		}),
+	"/new/": stringHandler{
+		contType: htmlType,
+		body:     `new`,
+	},
+})
+
+func TestRedirectsHTML(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(redirectHandler,
+		Redirects(RedirectHTML),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	for _, path := range []string{"/moved/index.html", "/temp/index.html"} {
+		stub := tmp.ReadFile("/public" + path)
+		c.Contains(stub, `<link rel="canonical" href="/new/">`)
+		c.Contains(stub, `<meta http-equiv="refresh" content="0; url=/new/">`)
+	}
+
+	c.Equal(
+		site.GetFile(tmp.Path("/public/moved/index.html")).OrigURL.Path,
+		"/moved/")
+}
+
+func TestRedirectsNetlify(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(redirectHandler,
+		Redirects(RedirectNetlify),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	c.Equal(tmp.ReadFile("/public/_redirects"), ``+
+		"/moved/ /new/ 301\n"+
+		"/temp/ /new/ 302\n")
+}
+
+func TestRedirectsNginx(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(redirectHandler,
+		Redirects(RedirectNginx),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	c.Equal(tmp.ReadFile("/public/redirects.nginx.conf"), ``+
+		"map $uri $acrylic_redirect_301 {\n"+
+		"\t\"/moved/\" \"/new/\";\n"+
+		"}\n"+
+		"map $uri $acrylic_redirect_302 {\n"+
+		"\t\"/temp/\" \"/new/\";\n"+
+		"}\n")
+}
+
+func TestRedirectsLoop(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/":  http.RedirectHandler("/a", http.StatusMovedPermanently),
+			"/a": http.RedirectHandler("/", http.StatusFound),
+		}),
+		Redirects(RedirectHTML),
+		Redirects(RedirectNetlify),
+		Output(tmp.Path("/public")),
+		FingerprintCache(""))
+
+	c.Equal(err, SiteError{
+		"/": {
+			RedirectLoopError{Start: "/", End: "/"},
+		},
+		"/a": {
+			RedirectLoopError{Start: "/a", End: "/a"},
+		},
+	})
+}
+
+func TestRedirectsNginxQuote(t *testing.T) {
+	c := check.New(t)
+
+	pg := &Page{
+		OrigURL:  url.URL{Path: "/caf\u00e9/\"a\\b\"\t"},
+		Status:   http.StatusFound,
+		Redirect: &Page{URL: url.URL{Path: "/new/"}},
+	}
+
+	c.Equal(string(nginxRedirects([]*Page{pg})), ``+
+		"map $uri $acrylic_redirect_302 {\n"+
+		"\t\"/caf\u00e9/\\\"a\\\\b\\\"\t\" \"/new/\";\n"+
+		"}\n")
+}
@@ -4,13 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"mime"
 	"net/http"
-	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -22,6 +23,11 @@ type crawlPath struct {
 const (
 	pathContentType = "application/x-acrylic-path"
 	variantHeader   = "X-Acrylic-Variant"
+	errorPageHeader = "X-Acrylic-Error-Page"
+
+	// Requested for ErrorPages. Lives at the root so that relative links in
+	// error pages resolve the same as they would from "/".
+	errorPageURL = "/.acrylic-error-page-%d"
 )
 
 // ServeFile is like http.ServeFile, except that if the requester is acrylic's
@@ -67,25 +73,42 @@ func Variant(w http.ResponseWriter, name string) {
 	w.Header().Set(variantHeader, name)
 }
 
+// ErrorPageStatus gets the status the crawler expects for the current request
+// when rendering an ErrorPage. It returns 0 for normal requests.
+func ErrorPageStatus(r *http.Request) int {
+	status, _ := strconv.Atoi(r.Header.Get(errorPageHeader))
+	return status
+}
+
 type response struct {
 	status int
 	header http.Header
 	body   responseBody
 }
 
-func newResponse(rr *httptest.ResponseRecorder) (*response, error) {
+func newResponse(rec *responseRecorder) (*response, error) {
+	sp, err := rec.finish()
+	if err != nil {
+		return nil, err
+	}
+
 	resp := response{
-		status: rr.Code,
-		header: rr.HeaderMap,
+		status: rec.code,
+		header: rec.header,
 	}
 
-	contType := rr.HeaderMap.Get("Content-Type")
+	contType := rec.header.Get("Content-Type")
+
+	switch {
+	case sp != nil:
+		resp.body.spill = sp
 
-	if contType != pathContentType {
-		resp.body.b = rr.Body.Bytes()
-	} else {
+	case contType != pathContentType:
+		resp.body.b = rec.buf.Bytes()
+
+	default:
 		var cp crawlPath
-		err := json.NewDecoder(rr.Body).Decode(&cp)
+		err := json.NewDecoder(&rec.buf).Decode(&cp)
 		if err != nil {
 			return nil, err
 		}
@@ -97,6 +120,7 @@ func newResponse(rr *httptest.ResponseRecorder) (*response, error) {
 	if contType != "" {
 		mediaType, _, err := mime.ParseMediaType(contType)
 		if err != nil {
+			resp.body.close()
 			return nil, err
 		}
 
@@ -106,10 +130,41 @@ func newResponse(rr *httptest.ResponseRecorder) (*response, error) {
 	return &resp, nil
 }
 
+// signature is a cheap stand-in for the contents of an in-memory body: if the
+// signature changes, so did the body. If the handler provided a validator and
+// the body wasn't transformed, that's used as-is; otherwise, this is the size
+// and CRC-32 of the body, which is still much faster than a cryptographic hash.
+func (resp *response) signature() string {
+	b := resp.body.b
+	size := int64(len(b))
+	if sp := resp.body.spill; sp != nil {
+		size = sp.size
+	}
+
+	if !resp.body.transformed {
+		if etag := resp.header.Get("ETag"); etag != "" {
+			return "etag:" + etag
+		}
+
+		if lm := resp.header.Get("Last-Modified"); lm != "" {
+			return fmt.Sprintf("lm:%d:%s", size, lm)
+		}
+	}
+
+	crc := crc32.ChecksumIEEE(b)
+	if sp := resp.body.spill; sp != nil {
+		crc = sp.crc
+	}
+
+	return fmt.Sprintf("crc:%d:%08x", size, crc)
+}
+
 type responseBody struct {
-	mediaType string // Parsed Content-Type
-	symSrc    string // Path to original file
-	b         []byte // If symSrc == ""
+	mediaType   string       // Parsed Content-Type
+	symSrc      string       // Path to original file
+	spill       *spilledBody // If the body was too big for memory
+	b           []byte       // If symSrc == "" && spill == nil
+	transformed bool         // If b isn't what the handler sent
 }
 
 func (body *responseBody) canSymlink() bool {
@@ -117,28 +172,61 @@ func (body *responseBody) canSymlink() bool {
 }
 
 func (body *responseBody) set(b []byte) {
+	body.close()
 	body.symSrc = ""
+	body.spill = nil
 	body.b = b
+	body.transformed = true
 }
 
 func (body *responseBody) get() ([]byte, error) {
-	if !body.canSymlink() {
-		return body.b, nil
-	}
+	switch {
+	case body.canSymlink():
+		return ioutil.ReadFile(body.symSrc)
 
-	f, err := os.Open(body.symSrc)
-	if err != nil {
-		return nil, err
-	}
+	case body.spill != nil:
+		return ioutil.ReadFile(body.spill.path)
 
-	defer f.Close()
-	return ioutil.ReadAll(f)
+	default:
+		return body.b, nil
+	}
 }
 
 func (body *responseBody) reader() (io.ReadCloser, error) {
-	if !body.canSymlink() {
+	switch {
+	case body.canSymlink():
+		return os.Open(body.symSrc)
+
+	case body.spill != nil:
+		return os.Open(body.spill.path)
+
+	default:
 		return ioutil.NopCloser(bytes.NewReader(body.b)), nil
 	}
+}
 
-	return os.Open(body.symSrc)
+// size gets the size of the body without reading it
+func (body *responseBody) size() (int64, error) {
+	switch {
+	case body.canSymlink():
+		info, err := os.Stat(body.symSrc)
+		if err != nil {
+			return 0, err
+		}
+
+		return info.Size(), nil
+
+	case body.spill != nil:
+		return body.spill.size, nil
+
+	default:
+		return int64(len(body.b)), nil
+	}
+}
+
+// close removes any temp file holding the body
+func (body *responseBody) close() {
+	if body.spill != nil {
+		body.spill.close()
+	}
 }
@@ -0,0 +1,190 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const robotsPath = "/robots.txt"
+
+type robotsRules []robotsRule
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+func (cr *crawler) loadRobots() {
+	if !cr.filter.useRobots {
+		return
+	}
+
+	req := httptest.NewRequest("GET", robotsPath, nil)
+	req = req.WithContext(cr.ctx)
+	req.Header.Set("User-Agent", UserAgent)
+
+	rr := httptest.NewRecorder()
+	cr.handler.ServeHTTP(rr, req)
+
+	switch {
+	case rr.Code == http.StatusOK:
+		cr.filter.robots = parseRobots(rr.Body.Bytes(), robotsAgent())
+
+	case rr.Code >= 400 && rr.Code < 500:
+		// No robots.txt, so no restrictions
+
+	default:
+		cr.addError(url.URL{Path: robotsPath}, ResponseError{
+			Status: rr.Code,
+			Body:   bytes.TrimSpace(rr.Body.Bytes()),
+		})
+	}
+}
+
+// robotsAgent gets the product token from UserAgent that robots.txt groups
+// are matched against
+func robotsAgent() string {
+	agent := UserAgent
+	if i := strings.IndexByte(agent, '/'); i != -1 {
+		agent = agent[:i]
+	}
+
+	return strings.ToLower(agent)
+}
+
+// parseRobots gets the rules from a robots.txt that apply to agent. Anything
+// that can't be parsed is ignored, per the RFC.
+func parseRobots(b []byte, agent string) robotsRules {
+	var (
+		ours, star robotsRules
+		agents     []string
+		inRules    bool // If the current group has started its rules
+		matchOurs  bool // If any group is for agent
+		matchStar  bool // If any group is for "*"
+	)
+
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i != -1 {
+			line = line[:i]
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon == -1 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimSpace(line[:colon]))
+		val := strings.TrimSpace(line[colon+1:])
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+
+			agents = append(agents, strings.ToLower(val))
+
+		case "allow", "disallow":
+			inRules = true
+
+			// An empty Disallow allows everything, which is the default
+			if val == "" {
+				continue
+			}
+
+			rule := robotsRule{
+				allow:   key == "allow",
+				pattern: val,
+				re:      robotsPattern(val),
+			}
+
+			for _, a := range agents {
+				switch a {
+				case agent:
+					ours = append(ours, rule)
+
+				case "*":
+					star = append(star, rule)
+				}
+			}
+		}
+
+		// Groups count even if they have no rules
+		for _, a := range agents {
+			matchOurs = matchOurs || a == agent
+			matchStar = matchStar || a == "*"
+		}
+	}
+
+	if matchOurs {
+		return ours
+	}
+
+	if matchStar {
+		return star
+	}
+
+	return nil
+}
+
+func robotsPattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '*':
+			b.WriteString(".*")
+
+		case c == '$' && i == len(pattern)-1:
+			b.WriteString("$")
+
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return regexp.MustCompile(b.String())
+}
+
+func (rules robotsRules) allowed(u *url.URL) bool {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+
+	// robots.txt itself is always allowed
+	if p == robotsPath {
+		return true
+	}
+
+	if u.RawQuery != "" {
+		p += "?" + u.RawQuery
+	}
+
+	var best *robotsRule
+	for i, rule := range rules {
+		if !rule.re.MatchString(p) {
+			continue
+		}
+
+		better := best == nil ||
+			len(rule.pattern) > len(best.pattern) ||
+			(len(rule.pattern) == len(best.pattern) && rule.allow)
+
+		if better {
+			best = &rules[i]
+		}
+	}
+
+	return best == nil || best.allow
+}
@@ -0,0 +1,188 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestRobotsParse(t *testing.T) {
+	c := check.New(t)
+
+	tests := []struct {
+		name    string
+		robots  string
+		allowed map[string]bool
+	}{
+		{
+			name: "Star",
+			robots: `` +
+				"User-agent: *\n" +
+				"Disallow: /admin/\n" +
+				"Disallow: /*.php$\n" +
+				"Allow: /admin/public\n",
+			allowed: map[string]bool{
+				"/":                  true,
+				"/robots.txt":        true,
+				"/admin":             true,
+				"/admin/":            false,
+				"/admin/users":       false,
+				"/admin/public/page": true,
+				"/index.php":         false,
+				"/index.php?a=b":     true,
+				"/index.phps":        true,
+			},
+		},
+		{
+			name: "OurGroupWins",
+			robots: `` +
+				"User-agent: *\n" +
+				"Disallow: /\n" +
+				"\n" +
+				"User-agent: Acrylic # comment\n" +
+				"Disallow: /private\n",
+			allowed: map[string]bool{
+				"/":          true,
+				"/private":   false,
+				"/private/a": false,
+			},
+		},
+		{
+			name: "SharedGroup",
+			robots: `` +
+				"User-agent: googlebot\n" +
+				"User-agent: acrylic\n" +
+				"Disallow: /private\n" +
+				"User-agent: other\n" +
+				"Disallow: /\n",
+			allowed: map[string]bool{
+				"/":        true,
+				"/private": false,
+			},
+		},
+		{
+			name: "EmptyDisallow",
+			robots: `` +
+				"User-agent: *\n" +
+				"Disallow:\n",
+			allowed: map[string]bool{
+				"/":     true,
+				"/page": true,
+			},
+		},
+		{
+			name: "TieGoesToAllow",
+			robots: `` +
+				"User-agent: *\n" +
+				"Disallow: /page\n" +
+				"Allow: /page\n",
+			allowed: map[string]bool{
+				"/page": true,
+			},
+		},
+		{
+			name: "OtherAgents",
+			robots: `` +
+				"User-agent: googlebot\n" +
+				"Disallow: /\n",
+			allowed: map[string]bool{
+				"/": true,
+			},
+		},
+		{
+			name:   "Garbage",
+			robots: "<html>not robots</html>\nDisallow /\n",
+			allowed: map[string]bool{
+				"/": true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		rules := parseRobots([]byte(test.robots), robotsAgent())
+
+		for path, allowed := range test.allowed {
+			u, err := url.Parse(path)
+			c.Must.Nil(err)
+
+			c.Equal(rules.allowed(u), allowed, test.name, path)
+		}
+	}
+}
+
+func TestRobotsCrawl(t *testing.T) {
+	c := check.New(t)
+
+	tests := []struct {
+		name    string
+		robots  http.Handler
+		crawled bool
+		err     bool
+	}{
+		{
+			name: "Disallowed",
+			robots: stringHandler{
+				contType: "text/plain",
+				body:     "User-agent: *\nDisallow: /private\n",
+			},
+		},
+		{
+			name: "Allowed",
+			robots: stringHandler{
+				contType: "text/plain",
+				body:     "User-agent: *\nDisallow: /other\n",
+			},
+			crawled: true,
+		},
+		{
+			name:    "Missing",
+			robots:  http.NotFoundHandler(),
+			crawled: true,
+		},
+		{
+			name: "ServerError",
+			robots: http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, "broken", http.StatusInternalServerError)
+				}),
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		c.Run(test.name, func(c *check.C) {
+			tmp := testutil.NewTmpDir(c, nil)
+			defer tmp.Remove()
+
+			site, err := Crawl(
+				mux(map[string]http.Handler{
+					"/": stringHandler{
+						contType: htmlType,
+						body:     `<a href="/private">`,
+					},
+					"/private": stringHandler{
+						contType: htmlType,
+						body:     `private`,
+					},
+					robotsPath: test.robots,
+				}),
+				Output(tmp.Path("/public")),
+				FingerprintCache(""),
+				Robots())
+			if test.err {
+				c.Must.NotNil(err)
+				c.Contains(err.Error(), robotsPath)
+				return
+			}
+
+			c.Must.Nil(err)
+			tmp.DumpTree()
+
+			c.Equal(site.GetPage("/private") != nil, test.crawled)
+		})
+	}
+}
@@ -3,14 +3,18 @@ package crawl
 import (
 	"net/url"
 	"path"
+	"sort"
 	"strings"
 )
 
 // Site describes an entire crawled site
 type Site struct {
-	urls   map[string]*Page // Pages by full URL
-	pages  map[string]*Page // Pages by url.Path
-	claims map[string]*Page // Pages by absolute path. Dir claim if nil.
+	urls     map[string]*Page // Pages by full URL
+	pages    map[string]*Page // Pages by url.Path
+	claims   map[string]*Page // Pages by absolute path. Dir claim if nil.
+	errPages map[int]*Page    // Error pages by status
+	report   *DryRunReport    // Only set for dry runs
+	warnings SiteError
 }
 
 // Get the Page at the given URL.
@@ -28,6 +32,38 @@ func (s *Site) GetFile(path string) *Page {
 	return s.claims[absPath(path)]
 }
 
+// GetErrorPage gets the error page that was rendered for the given status.
+func (s *Site) GetErrorPage(status int) *Page {
+	return s.errPages[status]
+}
+
+// ErrorPages gets all rendered error pages, sorted by status.
+func (s *Site) ErrorPages() []*Page {
+	pgs := make([]*Page, 0, len(s.errPages))
+	for _, pg := range s.errPages {
+		pgs = append(pgs, pg)
+	}
+
+	sort.Slice(pgs, func(i, j int) bool {
+		return pgs[i].Status < pgs[j].Status
+	})
+
+	return pgs
+}
+
+// Warnings gets everything that went wrong during the crawl that wasn't bad
+// enough to fail it (eg. a MimeTypeMismatchError). Returns nil if there were no
+// warnings.
+func (s *Site) Warnings() SiteError {
+	return s.warnings
+}
+
+// DryRunReport gets the report of everything a DryRun crawl would have changed.
+// Returns nil if the crawl wasn't a dry run.
+func (s *Site) DryRunReport() *DryRunReport {
+	return s.report
+}
+
 func normURL(u *url.URL) *url.URL {
 	uu := *u
 
@@ -0,0 +1,212 @@
+package crawl
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sitemapPath    = "/sitemap.xml"
+	sitemapXMLNS   = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	sitemapMaxURLs = 50000 // Per the sitemap protocol
+	xmlType        = "application/xml"
+)
+
+type xmlURLSet struct {
+	XMLName xml.Name    `xml:"urlset"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	URLs    []xmlURLLoc `xml:"url"`
+}
+
+type xmlSitemapIndex struct {
+	XMLName  xml.Name    `xml:"sitemapindex"`
+	XMLNS    string      `xml:"xmlns,attr"`
+	Sitemaps []xmlURLLoc `xml:"sitemap"`
+}
+
+type xmlURLLoc struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapEntry struct {
+	loc     string
+	lastMod time.Time
+}
+
+func genSitemap(cr *crawler, baseURL string) error {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+
+	var entries []sitemapEntry
+	for _, pg := range cr.site.urls {
+		if !pg.inSitemap() {
+			continue
+		}
+
+		// Whichever URL claimed the output first owns it, and that might have
+		// had a query string. Static hosts ignore those, so drop it.
+		u := pg.URL
+		u.RawQuery = ""
+
+		entries = append(entries, sitemapEntry{
+			loc:     sitemapLoc(base, u),
+			lastMod: pg.lastMod,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].loc < entries[j].loc
+	})
+
+	files, err := buildSitemaps(base, entries, sitemapMaxURLs)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		pg, err := cr.genPage(file.path, xmlType)
+		if err != nil {
+			return err
+		}
+
+		err = pg.writeFile(file.b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (pg *Page) inSitemap() bool {
+	return !pg.IsExternal() &&
+		pg.Status == http.StatusOK &&
+		pg.Redirect == nil &&
+		pg.AliasOf == nil &&
+		pg.MediaType == htmlType &&
+		pg.OutputPath != ""
+}
+
+type sitemapFile struct {
+	path string
+	b    []byte
+}
+
+func buildSitemaps(
+	base *url.URL, entries []sitemapEntry, max int) ([]sitemapFile, error) {
+
+	if len(entries) <= max {
+		b, err := marshalSitemap(xmlURLSet{
+			XMLNS: sitemapXMLNS,
+			URLs:  sitemapLocs(entries),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return []sitemapFile{{path: sitemapPath, b: b}}, nil
+	}
+
+	var (
+		files []sitemapFile
+		index = xmlSitemapIndex{XMLNS: sitemapXMLNS}
+	)
+
+	for i := 0; i < len(entries); i += max {
+		end := i + max
+		if end > len(entries) {
+			end = len(entries)
+		}
+
+		chunk := entries[i:end]
+		path := fmt.Sprintf("/sitemap-%d.xml", len(files)+1)
+
+		b, err := marshalSitemap(xmlURLSet{
+			XMLNS: sitemapXMLNS,
+			URLs:  sitemapLocs(chunk),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, sitemapFile{path: path, b: b})
+
+		var lastMod time.Time
+		for _, entry := range chunk {
+			if entry.lastMod.After(lastMod) {
+				lastMod = entry.lastMod
+			}
+		}
+
+		index.Sitemaps = append(index.Sitemaps, xmlURLLoc{
+			Loc:     sitemapLoc(base, url.URL{Path: path}),
+			LastMod: sitemapTime(lastMod),
+		})
+	}
+
+	b, err := marshalSitemap(index)
+	if err != nil {
+		return nil, err
+	}
+
+	files = append(files, sitemapFile{path: sitemapPath, b: b})
+	return files, nil
+}
+
+// sitemapLoc gets the absolute URL of the site-relative u. The site is served
+// from base, so base's path is a prefix for u rather than something to resolve
+// u against.
+func sitemapLoc(base *url.URL, u url.URL) string {
+	loc := *base
+	loc.Path = strings.TrimSuffix(base.Path, "/") + u.Path
+	loc.RawPath = ""
+	loc.RawQuery = u.RawQuery
+	loc.Fragment = ""
+
+	return loc.String()
+}
+
+func sitemapLocs(entries []sitemapEntry) []xmlURLLoc {
+	locs := make([]xmlURLLoc, len(entries))
+	for i, entry := range entries {
+		locs[i] = xmlURLLoc{
+			Loc:     entry.loc,
+			LastMod: sitemapTime(entry.lastMod),
+		}
+	}
+
+	return locs
+}
+
+func sitemapTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339)
+}
+
+func marshalSitemap(v interface{}) ([]byte, error) {
+	var buff bytes.Buffer
+	buff.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buff)
+	enc.Indent("", "\t")
+
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	buff.WriteString("\n")
+	return buff.Bytes(), nil
+}
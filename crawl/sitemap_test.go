@@ -0,0 +1,168 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestSitemapBasic(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	lastMod := time.Date(2019, 2, 3, 4, 5, 6, 0, time.UTC)
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body: `` +
+					`<a href="/about/">about</a>` +
+					`<a href="/about/?alias=1">about</a>` +
+					`<a href="/old/">old</a>` +
+					`<link href="/all.css" rel="stylesheet">`,
+			},
+			"/about/": http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Last-Modified", lastMod.Format(http.TimeFormat))
+					stringHandler{
+						contType: htmlType,
+						body:     `about`,
+					}.ServeHTTP(w, r)
+				}),
+			"/old/": http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					http.Redirect(w, r, "/about/", http.StatusMovedPermanently)
+				}),
+			"/all.css": stringHandler{
+				contType: cssType,
+				body:     `body{}`,
+			},
+		}),
+		Sitemap("https://example.com"),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	sitemap := tmp.ReadFile("/public/sitemap.xml")
+	c.Contains(sitemap, `<loc>https://example.com/</loc>`)
+	c.Contains(sitemap, `<loc>https://example.com/about/</loc>`)
+	c.Contains(sitemap, `<lastmod>2019-02-03T04:05:06Z</lastmod>`)
+	c.NotContains(sitemap, `alias`)
+	c.NotContains(sitemap, `/old/`)
+	c.NotContains(sitemap, `all.css`)
+}
+
+func TestSitemapIndex(t *testing.T) {
+	c := check.New(t)
+
+	base, err := url.Parse("https://example.com/")
+	c.Must.Nil(err)
+
+	entries := []sitemapEntry{
+		{loc: "https://example.com/0/"},
+		{loc: "https://example.com/1/"},
+		{loc: "https://example.com/2/"},
+	}
+
+	files, err := buildSitemaps(base, entries, 2)
+	c.Must.Nil(err)
+	c.Must.Len(files, 3)
+
+	c.Equal(files[0].path, "/sitemap-1.xml")
+	c.Contains(string(files[0].b), "/0/")
+	c.Contains(string(files[0].b), "/1/")
+
+	c.Equal(files[1].path, "/sitemap-2.xml")
+	c.Contains(string(files[1].b), "/2/")
+
+	c.Equal(files[2].path, sitemapPath)
+	c.Contains(string(files[2].b), "<sitemapindex")
+	c.Contains(string(files[2].b), "https://example.com/sitemap-1.xml")
+	c.Contains(string(files[2].b), "https://example.com/sitemap-2.xml")
+}
+
+func TestSitemapLoc(t *testing.T) {
+	c := check.New(t)
+
+	tests := []struct {
+		base string
+		path string
+		loc  string
+	}{
+		{
+			base: "https://example.com",
+			path: "/",
+			loc:  "https://example.com/",
+		},
+		{
+			base: "https://example.com/",
+			path: "/post/",
+			loc:  "https://example.com/post/",
+		},
+		{
+			base: "https://example.com/blog/",
+			path: "/post/",
+			loc:  "https://example.com/blog/post/",
+		},
+		{
+			base: "https://example.com/blog",
+			path: "/sitemap-1.xml",
+			loc:  "https://example.com/blog/sitemap-1.xml",
+		},
+		{
+			base: "https://example.com/blog/?x=1#frag",
+			path: "/a b",
+			loc:  "https://example.com/blog/a%20b",
+		},
+	}
+
+	for _, test := range tests {
+		base, err := url.Parse(test.base)
+		c.Must.Nil(err)
+
+		c.Equal(
+			sitemapLoc(base, url.URL{Path: test.path}),
+			test.loc,
+			test.base, test.path)
+	}
+}
+
+func TestSitemapClaimCollision(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<a href="/sitemap.xml">sitemap</a>`,
+			},
+			"/sitemap.xml": stringHandler{
+				contType: "text/xml",
+				body:     `<urlset></urlset>`,
+			},
+		}),
+		Sitemap("https://example.com"),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")))
+
+	c.Equal(err, SiteError{
+		sitemapPath: {
+			FileAlreadyClaimedError{
+				File:     tmp.Path(filepath.Join("public", sitemapPath)),
+				OwnerURL: sitemapPath,
+			},
+		},
+	})
+}
@@ -0,0 +1,203 @@
+package crawl
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/thatguystone/acrylic/internal/cache"
+)
+
+// crawlStateVersion is bumped any time the format of the state changes
+const crawlStateVersion = 1
+
+type crawlState struct {
+	enabled bool
+	file    string
+	prev    map[string]*statePage // From the last crawl, by OrigURL
+}
+
+type crawlStateFile struct {
+	Version int
+	Pages   map[string]*statePage
+}
+
+type statePage struct {
+	ETag         string      `json:",omitempty"`
+	LastModified string      `json:",omitempty"`
+	Variant      string      `json:",omitempty"`
+	Headers      http.Header `json:",omitempty"` // Only those from Headers
+	MediaType    string      `json:",omitempty"`
+	Output       string      // Absolute path of the output file
+	Links        []stateLink `json:",omitempty"`
+}
+
+type stateLink struct {
+	URL       string // Absolute link, as resolved
+	Result    string // What the link resolved to
+	Integrity string `json:",omitempty"`
+}
+
+// init settles where the state lives once every option has been applied, and
+// loads the state from the last crawl. Like the fingerprint cache, the state is
+// just a cache, so any problems loading it are ignored.
+func (st *crawlState) init(fps *fingerprints) {
+	if !st.enabled {
+		return
+	}
+
+	if st.file == "" {
+		dir := cache.DefaultDir
+		if fps.cacheEnabled() {
+			dir = filepath.Dir(fps.cacheFile)
+		}
+
+		st.file = filepath.Join(dir, "crawl-state.json.gz")
+	}
+
+	f, err := os.Open(st.file)
+	if err != nil {
+		return
+	}
+
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return
+	}
+
+	var sf crawlStateFile
+	err = json.NewDecoder(gz).Decode(&sf)
+	if err == nil && sf.Version == crawlStateVersion {
+		st.prev = sf.Pages
+	}
+}
+
+// get gets what the last crawl saw for the given page, if its output can be
+// reused
+func (st *crawlState) get(pg *Page) *statePage {
+	// Error pages are expected to have a specific status, so they're always
+	// rendered
+	if !st.enabled || pg.errStatus != 0 {
+		return nil
+	}
+
+	prev := st.prev[pg.OrigURL.String()]
+	if prev == nil || !pg.cr.writer.canRead(prev.Output) {
+		return nil
+	}
+
+	return prev
+}
+
+// conditional adds the conditional headers for a page's previous output to req
+func (prev *statePage) conditional(req *http.Request) {
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+}
+
+func (cr *crawler) saveState() error {
+	st := &cr.state
+	if !st.enabled || cr.writer.dryRun {
+		return nil
+	}
+
+	sf := crawlStateFile{
+		Version: crawlStateVersion,
+		Pages:   make(map[string]*statePage),
+	}
+
+	for k, pg := range cr.site.urls {
+		if sp := pg.statePage(); sp != nil {
+			sf.Pages[k] = sp
+		}
+	}
+
+	err := filePrepWrite(st.file)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(st.file)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+
+	err = json.NewEncoder(gz).Encode(sf)
+	if err != nil {
+		return err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// statePage gets what needs to be saved for this page to be reused by the next
+// crawl. Returns nil for pages that can't be reused: anything without
+// validators couldn't get a 304.
+func (pg *Page) statePage() *statePage {
+	if pg.IsExternal() ||
+		pg.errStatus != 0 ||
+		pg.Status != http.StatusOK ||
+		pg.OutputPath == "" ||
+		(pg.etag == "" && pg.lastMod.IsZero()) {
+
+		return nil
+	}
+
+	sp := &statePage{
+		ETag:      pg.etag,
+		Variant:   pg.variant,
+		Headers:   pg.headers,
+		MediaType: pg.MediaType,
+		Output:    pg.OutputPath,
+	}
+
+	if !pg.lastMod.IsZero() {
+		sp.LastModified = pg.lastMod.UTC().Format(http.TimeFormat)
+	}
+
+	seen := make(map[string]struct{})
+	for _, rl := range pg.links {
+		to := rl.to.FollowRedirects()
+
+		u := rl.to.OrigURL
+		u.Fragment = rl.frag
+
+		if _, ok := seen[u.String()]; ok {
+			continue
+		}
+
+		seen[u.String()] = struct{}{}
+
+		link := stateLink{
+			URL:    u.String(),
+			Result: rl.url(to),
+		}
+
+		if to.AliasOf != nil {
+			to = to.AliasOf
+		}
+
+		link.Integrity = to.integrity
+		sp.Links = append(sp.Links, link)
+	}
+
+	return sp
+}
@@ -0,0 +1,131 @@
+package crawl
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+type etagHandler struct {
+	etag   string
+	body   string
+	served int
+}
+
+func (h *etagHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("ETag", h.etag)
+
+	if r.Header.Get("If-None-Match") == h.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.served++
+	w.Header().Set("Content-Type", htmlType)
+	io.WriteString(w, h.body)
+}
+
+func TestIncremental(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	var (
+		index = &etagHandler{
+			etag: `"index"`,
+			body: `<a href="/page">` + `<img src="/img.gif">`,
+		}
+		page = &etagHandler{
+			etag: `"page"`,
+			body: `<a href="/">`,
+		}
+		gif = string(testutil.GifBin)
+	)
+
+	var statuses map[string]int
+	crawl := func() {
+		statuses = make(map[string]int)
+
+		_, err := Crawl(
+			mux(map[string]http.Handler{
+				"/":     index,
+				"/page": page,
+				"/img.gif": http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						w.Header().Set("Content-Type", testutil.GifType)
+						io.WriteString(w, gif)
+					}),
+			}),
+			Output(tmp.Path("/public")),
+			FingerprintCache(tmp.Path(".cache/fingerprints")),
+			Incremental(""),
+			Fingerprint(func(u *url.URL, mediaType string) bool {
+				return mediaType == testutil.GifType
+			}),
+			Events(func(ev Event) {
+				if ev.Kind == EventRequestDone {
+					statuses[ev.Page.OrigURL.String()] = ev.Status
+				}
+			}))
+		c.Must.Nil(err)
+	}
+
+	gifPath := func() string {
+		fp, err := fingerprint(sha1.New, bytes.NewReader([]byte(gif)))
+		c.Must.Nil(err)
+		return fmt.Sprintf("/img.%s.gif", fp)
+	}
+
+	crawl()
+	tmp.DumpTree()
+
+	c.Equal(index.served, 1)
+	c.Equal(page.served, 1)
+	c.Contains(tmp.ReadFile("/public/index.html"), gifPath())
+
+	// Nothing changed, so everything is reused
+	crawl()
+
+	c.Equal(index.served, 1)
+	c.Equal(page.served, 1)
+	c.Equal(statuses["/"], http.StatusNotModified)
+	c.Equal(statuses["/page"], http.StatusNotModified)
+	c.Contains(tmp.ReadFile("/public/index.html"), gifPath())
+	c.Contains(tmp.ReadFile("/public/page"), `href=/`)
+
+	// The page itself didn't change, but what it links to did
+	gif += "changed"
+	crawl()
+
+	c.Equal(index.served, 2)
+	c.Equal(page.served, 1)
+	c.Contains(tmp.ReadFile("/public/index.html"), gifPath())
+
+	// The page changed
+	page.etag = `"page2"`
+	page.body = `changed`
+	crawl()
+
+	c.Equal(index.served, 2)
+	c.Equal(page.served, 2)
+	c.Equal(tmp.ReadFile("/public/page"), `changed`)
+
+	// Nothing to reuse, so no conditional request
+	err := os.Remove(tmp.Path("/public/page"))
+	c.Must.Nil(err)
+
+	crawl()
+
+	c.Equal(page.served, 3)
+	c.Equal(statuses["/page"], http.StatusOK)
+	c.Equal(tmp.ReadFile("/public/page"), `changed`)
+}
@@ -19,7 +19,11 @@ var (
 	defaultTransforms = map[string][]Transform{
 		htmlType: {transformHTML},
 		cssType:  {transformCSS},
+		jsType:   {transformJS},
+		jsText:   {transformJS},
 		jsonType: {transformJSON},
+		ldType:   {transformJSONLinks(defaultLDPaths...)},
+		manType:  {transformJSONLinks(defaultManifestPaths...)},
 		svgType:  {transformSVG},
 	}
 )
@@ -28,14 +32,9 @@ func init() {
 	Minify.AddFunc(htmlType, html.Minify)
 	Minify.AddFunc(cssType, css.Minify)
 	Minify.AddFunc(jsType, js.Minify)
+	Minify.AddFunc(jsText, js.Minify)
 	Minify.AddFunc(jsonType, json.Minify)
+	Minify.AddFunc(ldType, json.Minify)
+	Minify.AddFunc(manType, json.Minify)
 	Minify.AddFunc(svgType, svg.Minify)
 }
-
-func transformJSON(lr LinkResolver, b []byte) ([]byte, error) {
-	return Minify.Bytes(jsonType, b)
-}
-
-func transformSVG(lr LinkResolver, b []byte) ([]byte, error) {
-	return Minify.Bytes(svgType, b)
-}
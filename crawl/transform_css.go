@@ -1,26 +1,24 @@
 package crawl
 
 import (
-	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/tdewolff/parse/v2/css"
 )
 
 type cssTransform struct {
-	css     string
-	matches []cssMatch
+	toks  []string
+	links map[int]cssLink // Token index -> link
 }
 
-type cssMatch struct {
-	orig string
-	url  string
-	link ResolvedLinker
+type cssLink struct {
+	quote byte // Quote char, or 0 for an unquoted url()
+	isURL bool // If the link is wrapped in url()
+	url   string
+	link  ResolvedLinker
 }
 
-var (
-	reCSSURL    = regexp.MustCompile(`url\(["']?(.*?)["']?\)`)
-	reCSSImport = regexp.MustCompile(`@import ["'](.*?)["']`)
-)
-
 func transformCSS(lr LinkResolver, b []byte) ([]byte, error) {
 	b = newCSSTransform(lr, string(b)).get()
 
@@ -29,35 +27,251 @@ func transformCSS(lr LinkResolver, b []byte) ([]byte, error) {
 	return Minify.Bytes(cssType, b)
 }
 
-func newCSSTransform(lr LinkResolver, css string) (tf cssTransform) {
-	tf.css = css
-	tf.extract(lr, reCSSURL)
-	tf.extract(lr, reCSSImport)
+// newCSSTransform finds every link in the given CSS and resolves it. Links are
+// any url() (which covers `src:` in @font-face), strings in @import, and
+// strings in image-set(). Anything in comments or other strings is left alone.
+//
+// This is also used for <style> and style="" in HTML, so it can't return an
+// error: anything it can't make sense of is left as-is.
+func newCSSTransform(lr LinkResolver, s string) (tf cssTransform) {
+	tf.links = make(map[int]cssLink)
+
+	var (
+		l        = css.NewLexer(strings.NewReader(s))
+		inImport bool     // Just saw @import
+		funcs    []string // Stack of open functions/parens
+	)
+
+	for {
+		tt, data := l.Next()
+		if tt == css.ErrorToken {
+			break
+		}
+
+		i := len(tf.toks)
+		tf.toks = append(tf.toks, string(data))
+
+		switch tt {
+		case css.WhitespaceToken, css.CommentToken:
+			continue
+
+		case css.AtKeywordToken:
+			inImport = strings.EqualFold(string(data), "@import")
+			continue
+
+		case css.FunctionToken:
+			funcs = append(funcs, strings.ToLower(string(data)))
+
+		case css.LeftParenthesisToken:
+			funcs = append(funcs, "(")
+
+		case css.RightParenthesisToken:
+			if len(funcs) > 0 {
+				funcs = funcs[:len(funcs)-1]
+			}
+
+		case css.URLToken:
+			quote, u, ok := cssUnquoteURL(data)
+			if ok {
+				tf.links[i] = cssLink{
+					quote: quote,
+					isURL: true,
+					url:   u,
+					link:  lr.ResolveLink(u),
+				}
+			}
+
+		case css.StringToken:
+			inImageSet := false
+			if len(funcs) > 0 {
+				switch funcs[len(funcs)-1] {
+				case "image-set(", "-webkit-image-set(":
+					inImageSet = true
+				}
+			}
+
+			if !inImport && !inImageSet {
+				break
+			}
+
+			quote, u, ok := cssUnquote(data)
+			if ok {
+				tf.links[i] = cssLink{
+					quote: quote,
+					url:   u,
+					link:  lr.ResolveLink(u),
+				}
+			}
+		}
+
+		inImport = false
+	}
+
 	return
 }
 
-func (tf *cssTransform) extract(lr LinkResolver, re *regexp.Regexp) {
-	for _, m := range re.FindAllStringSubmatch(tf.css, -1) {
-		tf.matches = append(tf.matches, cssMatch{
-			orig: m[0],
-			url:  m[1],
-			link: lr.ResolveLink(m[1]),
-		})
+func (tf cssTransform) get() []byte {
+	var b strings.Builder
+
+	for i, tok := range tf.toks {
+		link, ok := tf.links[i]
+		if !ok {
+			b.WriteString(tok)
+			continue
+		}
+
+		rel := link.link.Get()
+		if rel == link.url {
+			b.WriteString(tok)
+			continue
+		}
+
+		switch {
+		case !link.isURL:
+			b.WriteString(cssQuote(link.quote, rel))
+
+		case link.quote == 0 && css.IsURLUnquoted([]byte(rel)):
+			b.WriteString("url(" + rel + ")")
+
+		default:
+			quote := link.quote
+			if quote == 0 {
+				quote = '"'
+			}
+
+			b.WriteString("url(" + cssQuote(quote, rel) + ")")
+		}
 	}
+
+	return []byte(b.String())
 }
 
-func (tf cssTransform) get() []byte {
-	replaces := make([]string, 0, len(tf.matches)*2)
+// cssUnquoteURL gets the URL from a url() token
+func cssUnquoteURL(tok []byte) (quote byte, s string, ok bool) {
+	start := strings.IndexByte(string(tok), '(')
+	if start == -1 {
+		return
+	}
+
+	inner := string(tok[start+1:])
+	inner = strings.TrimSuffix(inner, ")") // Might be missing at EOF
+	inner = strings.Trim(inner, cssWhitespace)
+
+	if len(inner) > 0 && (inner[0] == '"' || inner[0] == '\'') {
+		return cssUnquote([]byte(inner))
+	}
+
+	s, ok = cssUnescape(inner)
+	return 0, s, ok
+}
+
+const cssWhitespace = " \t\n\r\f"
+
+func cssUnquote(lit []byte) (quote byte, s string, ok bool) {
+	if len(lit) < 1 {
+		return
+	}
+
+	quote = lit[0]
+	lit = lit[1:]
+
+	// The closing quote might be missing at EOF
+	if len(lit) > 0 && lit[len(lit)-1] == quote {
+		lit = lit[:len(lit)-1]
+	}
+
+	s, ok = cssUnescape(string(lit))
+	return
+}
+
+// cssUnescape resolves CSS escapes, per CSS Syntax Level 3, §4.3.7
+func cssUnescape(s string) (string, bool) {
+	if !strings.Contains(s, `\`) {
+		return s, true
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		if i >= len(s) {
+			return "", false
+		}
+
+		c = s[i]
+		switch {
+		case c == '\n' || c == '\f':
+			// Line continuation in a string
+
+		case c == '\r':
+			if i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+
+		case isHexDigit(c):
+			end := i
+			for end < len(s) && end-i < 6 && isHexDigit(s[end]) {
+				end++
+			}
+
+			cp, _ := strconv.ParseUint(s[i:end], 16, 32)
+			if cp == 0 || cp > 0x10ffff || (cp >= 0xd800 && cp <= 0xdfff) {
+				cp = 0xfffd
+			}
+
+			b.WriteRune(rune(cp))
+
+			// A single whitespace after a hex escape is part of the escape
+			if end < len(s) && strings.IndexByte(cssWhitespace, s[end]) != -1 {
+				end++
+			}
+
+			i = end - 1
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), true
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') ||
+		(c >= 'a' && c <= 'f') ||
+		(c >= 'A' && c <= 'F')
+}
+
+func cssQuote(quote byte, s string) string {
+	var b strings.Builder
+	b.WriteByte(quote)
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case quote, '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+
+		case '\n':
+			b.WriteString(`\a `)
+
+		case '\r':
+			b.WriteString(`\d `)
 
-	for _, match := range tf.matches {
-		rel := match.link.Get()
+		case '\f':
+			b.WriteString(`\c `)
 
-		if match.url != rel {
-			replaces = append(replaces,
-				match.orig, strings.Replace(match.orig, match.url, rel, 1))
+		default:
+			b.WriteByte(c)
 		}
 	}
 
-	out := strings.NewReplacer(replaces...).Replace(tf.css)
-	return []byte(out)
+	b.WriteByte(quote)
+	return b.String()
 }
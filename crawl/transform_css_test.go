@@ -25,6 +25,77 @@ func TestTransformCSSRewrites(t *testing.T) {
 	c.NotContains(string(out), "img.gif")
 }
 
+func TestTransformCSSTokens(t *testing.T) {
+	c := check.New(t)
+
+	lr := linkRewrite{
+		"/img.gif":    "/img.hash.gif",
+		"/a b.gif":    "/a b.hash.gif",
+		`/q"uote.gif`: "/quote.hash.gif",
+		"/all.css":    "/all.hash.css",
+		"/font.woff2": "/font.hash.woff2",
+	}
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{
+			in:  `a { background: url(/img.gif) }`,
+			out: `a { background: url(/img.hash.gif) }`,
+		},
+		{
+			in:  `a { background: URL( "/img.gif" ) }`,
+			out: `a { background: url("/img.hash.gif") }`,
+		},
+		{
+			in:  `a { background: url('/a b.gif') }`,
+			out: `a { background: url('/a b.hash.gif') }`,
+		},
+		{
+			in:  `a { background: url("/q\"uote.gif") }`,
+			out: `a { background: url("/quote.hash.gif") }`,
+		},
+		{
+			in:  `a { background: url(/\69mg.gif) }`,
+			out: `a { background: url(/img.hash.gif) }`,
+		},
+		{
+			in:  `@import "/all.css";`,
+			out: `@import "/all.hash.css";`,
+		},
+		{
+			in:  `@import url(/all.css) layer(base);`,
+			out: `@import url(/all.hash.css) layer(base);`,
+		},
+		{
+			in:  `a { background: image-set("/img.gif" 1x, url(/img.gif) 2x) }`,
+			out: `a { background: image-set("/img.hash.gif" 1x, url(/img.hash.gif) 2x) }`,
+		},
+		{
+			in:  `a { background: -webkit-image-set("/img.gif" 1x) }`,
+			out: `a { background: -webkit-image-set("/img.hash.gif" 1x) }`,
+		},
+		{
+			in:  `@font-face { src: url(/font.woff2) format("woff2") }`,
+			out: `@font-face { src: url(/font.hash.woff2) format("woff2") }`,
+		},
+		{
+			in:  `/* url(/img.gif) */ a { content: "/img.gif url(/img.gif)" }`,
+			out: `/* url(/img.gif) */ a { content: "/img.gif url(/img.gif)" }`,
+		},
+		{
+			in:  `a { background: url(/missing.gif) }`,
+			out: `a { background: url(/missing.gif) }`,
+		},
+	}
+
+	for _, test := range tests {
+		out := newCSSTransform(lr, test.in).get()
+		c.Equal(string(out), test.out, test.in)
+	}
+}
+
 func TestTransformCSSError(t *testing.T) {
 	c := check.New(t)
 
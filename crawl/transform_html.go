@@ -2,17 +2,111 @@ package crawl
 
 import (
 	"bytes"
+	"strings"
 
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 )
 
+// An AttrKind describes how an HTML attribute holds links
+type AttrKind int
+
+const (
+	// AttrURL is an attribute that is a single URL (eg. href)
+	AttrURL AttrKind = iota + 1
+
+	// AttrSrcSet is an attribute that is a list of image candidates (eg.
+	// srcset)
+	AttrSrcSet
+
+	// AttrCSS is an attribute that contains inline CSS (eg. style)
+	AttrCSS
+
+	// attrMeta is <meta content="">, which only has a link for some kinds of
+	// <meta>
+	attrMeta
+)
+
+// htmlAttrs maps element names to attribute names to the kind of link the
+// attribute holds. Element "*" matches every element. Namespaced attributes are
+// written as they are in HTML (eg. "xlink:href").
+type htmlAttrs map[string]map[string]AttrKind
+
+var defaultHTMLAttrs = htmlAttrs{
+	"*": {
+		"src":        AttrURL,
+		"href":       AttrURL,
+		"xlink:href": AttrURL,
+		"srcset":     AttrSrcSet,
+		"style":      AttrCSS,
+	},
+	"video":  {"poster": AttrURL},
+	"object": {"data": AttrURL},
+	"form":   {"action": AttrURL},
+	"button": {"formaction": AttrURL},
+	"input":  {"formaction": AttrURL},
+	"link":   {"imagesrcset": AttrSrcSet},
+	"meta":   {"content": attrMeta},
+}
+
+// <meta property="" content=""> and <meta name="" content=""> that hold links
+var htmlMetaLinks = map[string]struct{}{
+	"og:image":            {},
+	"og:image:url":        {},
+	"og:image:secure_url": {},
+	"og:video":            {},
+	"og:video:url":        {},
+	"og:audio":            {},
+	"og:audio:url":        {},
+	"twitter:image":       {},
+}
+
 func transformHTML(lr LinkResolver, b []byte) ([]byte, error) {
+	return defaultHTMLAttrs.transform(lr, b)
+}
+
+func (attrs htmlAttrs) clone() htmlAttrs {
+	c := make(htmlAttrs, len(attrs))
+	for elem, kinds := range attrs {
+		c[elem] = make(map[string]AttrKind, len(kinds))
+		for attr, kind := range kinds {
+			c[elem][attr] = kind
+		}
+	}
+
+	return c
+}
+
+func (attrs htmlAttrs) add(elem, attr string, kind AttrKind) {
+	elem = strings.ToLower(elem)
+	if attrs[elem] == nil {
+		attrs[elem] = make(map[string]AttrKind)
+	}
+
+	attrs[elem][strings.ToLower(attr)] = kind
+}
+
+func (attrs htmlAttrs) kind(n *html.Node, attr *html.Attribute) AttrKind {
+	name := attr.Key
+	if attr.Namespace != "" {
+		name = attr.Namespace + ":" + attr.Key
+	}
+
+	if kind, ok := attrs[n.Data][name]; ok {
+		return kind
+	}
+
+	return attrs["*"][name]
+}
+
+func (attrs htmlAttrs) transform(origLR LinkResolver, b []byte) ([]byte, error) {
 	doc, err := html.Parse(bytes.NewReader(b))
 	if err != nil {
 		return nil, err
 	}
 
+	baseNode, baseLR := htmlBase(origLR, doc)
+
 	var cbs []func()
 
 	var visit func(parent, n *html.Node)
@@ -21,6 +115,12 @@ func transformHTML(lr LinkResolver, b []byte) ([]byte, error) {
 			visit(n, cn)
 		}
 
+		// The <base> element's own href is relative to the page, not itself
+		lr := baseLR
+		if n == baseNode {
+			lr = origLR
+		}
+
 		if parent != nil && parent.DataAtom == atom.Style {
 			tf := newCSSTransform(lr, n.Data)
 			cbs = append(cbs, func() {
@@ -28,6 +128,22 @@ func transformHTML(lr LinkResolver, b []byte) ([]byte, error) {
 			})
 		}
 
+		if parent != nil && parent.DataAtom == atom.Script {
+			typ := getAttr(parent, "type")
+			if typ != nil && strings.EqualFold(typ.Val, ldType) {
+				// Structured data is only metadata: rather than failing
+				// the page, leave anything that's invalid alone.
+				tf, err := newJSONTransform(lr, []byte(n.Data), ldPaths)
+				if err != nil {
+					lr.Warn(err)
+				} else {
+					cbs = append(cbs, func() {
+						n.Data = string(tf.get())
+					})
+				}
+			}
+		}
+
 		if n.Type != html.ElementNode {
 			return
 		}
@@ -35,24 +151,39 @@ func transformHTML(lr LinkResolver, b []byte) ([]byte, error) {
 		for i := range n.Attr {
 			attr := &n.Attr[i]
 
-			switch attr.Key {
-			case "src", "href":
-				res := lr.ResolveLink(attr.Val)
+			switch attrs.kind(n, attr) {
+			case AttrURL:
+				var res ResolvedLinker
+				if n == baseNode && attr.Key == "href" {
+					res = lookupLink(lr, attr.Val)
+				} else {
+					res = lr.ResolveLink(attr.Val)
+				}
+
 				cbs = append(cbs, func() {
 					attr.Val = res.Get()
 				})
 
-			case "srcset":
+			case AttrSrcSet:
 				tf := newSrcSetTransform(lr, attr.Val)
 				cbs = append(cbs, func() {
 					attr.Val = tf.get()
 				})
 
-			case "style":
+			case AttrCSS:
 				tf := newCSSTransform(lr, attr.Val)
 				cbs = append(cbs, func() {
 					attr.Val = string(tf.get())
 				})
+
+			case attrMeta:
+				pre, link, post, ok := htmlMetaLink(n, attr.Val)
+				if ok {
+					res := lr.ResolveLink(link)
+					cbs = append(cbs, func() {
+						attr.Val = pre + res.Get() + post
+					})
+				}
 			}
 		}
 	}
@@ -70,3 +201,114 @@ func transformHTML(lr LinkResolver, b []byte) ([]byte, error) {
 
 	return Minify.Bytes(htmlType, buff.Bytes())
 }
+
+// htmlMetaLink gets the link out of a <meta>'s content, if it has one, along
+// with everything around the link.
+func htmlMetaLink(n *html.Node, content string) (pre, link, post string, ok bool) {
+	if equiv := getAttr(n, "http-equiv"); equiv != nil {
+		if strings.EqualFold(equiv.Val, "refresh") {
+			return parseMetaRefresh(content)
+		}
+
+		return
+	}
+
+	for _, key := range []string{"property", "name"} {
+		attr := getAttr(n, key)
+		if attr == nil {
+			continue
+		}
+
+		if _, isLink := htmlMetaLinks[strings.ToLower(attr.Val)]; isLink {
+			return "", content, "", true
+		}
+	}
+
+	return
+}
+
+// parseMetaRefresh splits a refresh value (eg. "0; url='/path'") around its
+// URL
+func parseMetaRefresh(content string) (pre, link, post string, ok bool) {
+	i := strings.IndexAny(content, ";,")
+	if i == -1 {
+		return
+	}
+
+	i++
+	for i < len(content) && content[i] == ' ' {
+		i++
+	}
+
+	rest := content[i:]
+	if len(rest) >= 3 && strings.EqualFold(rest[:3], "url") {
+		j := 3
+		for j < len(rest) && rest[j] == ' ' {
+			j++
+		}
+
+		if j < len(rest) && rest[j] == '=' {
+			j++
+			for j < len(rest) && rest[j] == ' ' {
+				j++
+			}
+
+			i += j
+		}
+	}
+
+	link = content[i:]
+	if link != "" && (link[0] == '"' || link[0] == '\'') {
+		quote := link[0]
+		link = link[1:]
+		i++
+
+		if end := strings.IndexByte(link, quote); end != -1 {
+			link = link[:end]
+		}
+	}
+
+	if link == "" {
+		return
+	}
+
+	return content[:i], link, content[i+len(link):], true
+}
+
+// htmlBase finds the first <base href> in the document's <head> and gets a
+// LinkResolver that resolves relative to it. If there isn't one, the given
+// LinkResolver is returned as-is.
+func htmlBase(lr LinkResolver, doc *html.Node) (*html.Node, LinkResolver) {
+	head := findElem(doc, atom.Head)
+	if head == nil {
+		return nil, lr
+	}
+
+	for n := head.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type != html.ElementNode || n.DataAtom != atom.Base {
+			continue
+		}
+
+		href := getAttr(n, "href")
+		if href != nil {
+			return n, lr.WithBase(href.Val)
+		}
+	}
+
+	return nil, lr
+}
+
+func findElem(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+
+	for cn := n.FirstChild; cn != nil; cn = cn.NextSibling {
+		found := findElem(cn, a)
+		if found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
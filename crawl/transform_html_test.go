@@ -1,8 +1,10 @@
 package crawl
 
 import (
+	"net/http"
 	"testing"
 
+	"github.com/thatguystone/acrylic/internal/testutil"
 	"github.com/thatguystone/cog/check"
 )
 
@@ -24,3 +26,95 @@ func TestTransformHTMLRewrites(t *testing.T) {
 	c.Contains(string(out), "img.hash.gif")
 	c.NotContains(string(out), "img.gif")
 }
+
+func TestTransformHTMLAttrs(t *testing.T) {
+	c := check.New(t)
+
+	lr := linkRewrite{
+		"/img.gif": "/img.hash.gif",
+	}
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{
+			in:  `<video poster="/img.gif"></video>`,
+			out: `<video poster=/img.hash.gif></video>`,
+		},
+		{
+			in:  `<object data="/img.gif"></object>`,
+			out: `<object data=/img.hash.gif></object>`,
+		},
+		{
+			in:  `<form action="/img.gif"></form>`,
+			out: `<form action=/img.hash.gif></form>`,
+		},
+		{
+			in:  `<button formaction="/img.gif"></button>`,
+			out: `<button formaction=/img.hash.gif></button>`,
+		},
+		{
+			in:  `<link rel="preload" imagesrcset="/img.gif 2x">`,
+			out: `<link rel=preload imagesrcset="/img.hash.gif 2x">`,
+		},
+		{
+			in:  `<svg><use xlink:href="/img.gif"></use></svg>`,
+			out: `<use xlink:href="/img.hash.gif"`,
+		},
+		{
+			in:  `<meta http-equiv="refresh" content="0;url=/img.gif">`,
+			out: `<meta http-equiv=refresh content="0;url=/img.hash.gif">`,
+		},
+		{
+			in:  `<meta http-equiv="refresh" content="0; URL='/img.gif'">`,
+			out: `<meta http-equiv=refresh content="0; URL='/img.hash.gif'">`,
+		},
+		{
+			in:  `<meta property="og:image" content="/img.gif">`,
+			out: `<meta property=og:image content=/img.hash.gif>`,
+		},
+		{
+			in:  `<meta name="description" content="/img.gif">`,
+			out: `<meta name=description content=/img.gif>`,
+		},
+		{
+			in:  `<img data-src="/img.gif">`,
+			out: `<img data-src=/img.gif>`,
+		},
+	}
+
+	for _, test := range tests {
+		out, err := transformHTML(lr, []byte(test.in))
+		c.Must.Nil(err)
+		c.Contains(string(out), test.out, test.in)
+	}
+}
+
+func TestTransformHTMLCustomAttrs(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	_, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<img data-src="img.gif" data-srcset="img.gif 2x">`,
+			},
+			"/img.gif": stringHandler{
+				contType: testutil.GifType,
+				body:     string(testutil.GifBin),
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(""),
+		HTMLAttr("img", "data-src", AttrURL),
+		HTMLAttr("*", "data-srcset", AttrSrcSet))
+	c.Must.Nil(err)
+
+	index := tmp.ReadFile("/public/index.html")
+	c.Contains(index, `data-src=/img.gif`)
+	c.Contains(index, `data-srcset="/img.gif 2x"`)
+}
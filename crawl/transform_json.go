@@ -0,0 +1,259 @@
+package crawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+var (
+	// Paths in a web app manifest that hold URLs
+	defaultManifestPaths = []string{
+		"start_url",
+		"icons[].src",
+		"screenshots[].src",
+		"shortcuts[].url",
+		"shortcuts[].icons[].src",
+	}
+
+	// Paths in JSON-LD that hold URLs. Since an image or logo may be given as a
+	// URL, a list of URLs, or an ImageObject, "**.url" covers the latter.
+	defaultLDPaths = []string{
+		"**.url",
+		"**.image",
+		"**.logo",
+	}
+)
+
+// For <script type="application/ld+json"> in HTML
+var ldPaths = parseJSONPaths(defaultLDPaths)
+
+type jsonTransform struct {
+	parts []string
+	links map[int]jsonLink // Part index -> link
+}
+
+type jsonLink struct {
+	url  string
+	link ResolvedLinker
+}
+
+// A jsonPath matches the location of a value in a JSON document. Each segment
+// is either an object key, "[]" for any array element, or "**" for any number
+// of segments.
+type jsonPath []string
+
+func transformJSON(lr LinkResolver, b []byte) ([]byte, error) {
+	return Minify.Bytes(jsonType, b)
+}
+
+// transformJSONLinks creates a Transform that resolves every string in a JSON
+// document at any of the given paths. Paths are written as keys separated by
+// dots, with "[]" for any array element and "**" for any number of levels (eg.
+// "icons[].src" or "**.url"). A string in an array also matches the path of
+// the array, so "**.image" matches both `"image": "a.png"` and `"image":
+// ["a.png"]`.
+//
+// Since the document is rebuilt from its tokens, the output is always
+// minified.
+func transformJSONLinks(paths ...string) Transform {
+	ps := parseJSONPaths(paths)
+
+	return func(lr LinkResolver, b []byte) ([]byte, error) {
+		tf, err := newJSONTransform(lr, b, ps)
+		if err != nil {
+			return nil, err
+		}
+
+		return tf.get(), nil
+	}
+}
+
+func parseJSONPaths(paths []string) []jsonPath {
+	ps := make([]jsonPath, 0, len(paths))
+
+	for _, path := range paths {
+		var p jsonPath
+		for _, seg := range strings.Split(path, ".") {
+			key := strings.TrimRight(seg, "[]")
+			if key != "" {
+				p = append(p, key)
+			}
+
+			for i := 0; i < (len(seg)-len(key))/2; i++ {
+				p = append(p, "[]")
+			}
+		}
+
+		ps = append(ps, p)
+	}
+
+	return ps
+}
+
+func (p jsonPath) match(path []string) bool {
+	if len(p) == 0 {
+		return len(path) == 0
+	}
+
+	if p[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if p[1:].match(path[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return len(path) > 0 && p[0] == path[0] && p[1:].match(path[1:])
+}
+
+func matchJSONPaths(ps []jsonPath, path []string) bool {
+	for {
+		for _, p := range ps {
+			if p.match(path) {
+				return true
+			}
+		}
+
+		if len(path) == 0 || path[len(path)-1] != "[]" {
+			return false
+		}
+
+		path = path[:len(path)-1]
+	}
+}
+
+func newJSONTransform(
+	lr LinkResolver, b []byte, ps []jsonPath) (*jsonTransform, error) {
+
+	tf := &jsonTransform{
+		links: make(map[int]jsonLink),
+	}
+
+	type container struct {
+		obj bool
+		n   int // Number of tokens so far
+	}
+
+	var (
+		stack []container
+		path  []string // Path to the current container
+		key   string   // Key of the next value in an object
+	)
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && len(stack) == 0 {
+			break
+		}
+
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				path = path[:len(path)-1]
+			}
+
+			tf.parts = append(tf.parts, delim.String())
+			continue
+		}
+
+		var seg string // Path segment of this value
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+
+			if top.n > 0 {
+				if top.obj && top.n%2 == 1 {
+					tf.parts = append(tf.parts, ":")
+				} else {
+					tf.parts = append(tf.parts, ",")
+				}
+			}
+
+			top.n++
+
+			if top.obj && top.n%2 == 1 {
+				key, _ = tok.(string)
+				tf.parts = append(tf.parts, jsonString(key))
+				continue
+			}
+
+			seg = "[]"
+			if top.obj {
+				seg = key
+			}
+		}
+
+		switch tok := tok.(type) {
+		case json.Delim:
+			if len(stack) > 0 {
+				path = append(path, seg)
+			}
+
+			stack = append(stack, container{obj: tok == '{'})
+			tf.parts = append(tf.parts, tok.String())
+
+		case string:
+			if len(stack) > 0 &&
+				matchJSONPaths(ps, append(path[:len(path):len(path)], seg)) {
+
+				tf.links[len(tf.parts)] = jsonLink{
+					url:  tok,
+					link: lr.ResolveLink(tok),
+				}
+			}
+
+			tf.parts = append(tf.parts, jsonString(tok))
+
+		case json.Number:
+			tf.parts = append(tf.parts, tok.String())
+
+		case bool:
+			if tok {
+				tf.parts = append(tf.parts, "true")
+			} else {
+				tf.parts = append(tf.parts, "false")
+			}
+
+		case nil:
+			tf.parts = append(tf.parts, "null")
+		}
+	}
+
+	return tf, nil
+}
+
+func (tf *jsonTransform) get() []byte {
+	var b strings.Builder
+
+	for i, part := range tf.parts {
+		if link, ok := tf.links[i]; ok {
+			if rel := link.link.Get(); rel != link.url {
+				part = jsonString(rel)
+			}
+		}
+
+		b.WriteString(part)
+	}
+
+	return []byte(b.String())
+}
+
+func jsonString(s string) string {
+	// Marshal escapes <, > and &, so this is safe to put into a <script>
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,144 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestTransformJSONPaths(t *testing.T) {
+	c := check.New(t)
+
+	tests := []struct {
+		pattern string
+		path    []string
+		match   bool
+	}{
+		{"start_url", []string{"start_url"}, true},
+		{"start_url", []string{"icons", "[]", "start_url"}, false},
+		{"icons[].src", []string{"icons", "[]", "src"}, true},
+		{"icons[].src", []string{"icons", "src"}, false},
+		{"**.url", []string{"url"}, true},
+		{"**.url", []string{"[]", "publisher", "url"}, true},
+		{"**.url", []string{"url", "name"}, false},
+		{"**.image", []string{"image", "[]"}, true},
+		{"[][]", []string{"[]", "[]"}, true},
+	}
+
+	for _, test := range tests {
+		ps := parseJSONPaths([]string{test.pattern})
+		c.Equal(matchJSONPaths(ps, test.path), test.match, test)
+	}
+}
+
+func TestTransformJSONManifest(t *testing.T) {
+	c := check.New(t)
+
+	const manifest = `{
+		"name": "App",
+		"start_url": "/index.html?pwa=1",
+		"icons": [
+			{"src": "/icon.png", "sizes": "192x192"},
+			{"src": "/other.png", "purpose": ["any", "maskable"]}
+		],
+		"screenshots": [{"src": "/icon.png"}],
+		"display": "standalone",
+		"scale": 1.5,
+		"dark": true,
+		"theme": null
+	}`
+
+	lr := linkRewrite{
+		"/index.html?pwa=1": "/?pwa=1",
+		"/icon.png":         "/icon.hash.png",
+	}
+
+	tf := transformJSONLinks(defaultManifestPaths...)
+	out, err := tf(lr, []byte(manifest))
+	c.Must.Nil(err)
+	c.Equal(string(out), ``+
+		`{"name":"App","start_url":"/?pwa=1",`+
+		`"icons":[{"src":"/icon.hash.png","sizes":"192x192"},`+
+		`{"src":"/other.png","purpose":["any","maskable"]}],`+
+		`"screenshots":[{"src":"/icon.hash.png"}],`+
+		`"display":"standalone","scale":1.5,"dark":true,"theme":null}`)
+}
+
+func TestTransformJSONLD(t *testing.T) {
+	c := check.New(t)
+
+	const ld = `[{
+		"@context": "https://schema.org",
+		"@type": "Organization",
+		"url": "/about",
+		"logo": "/logo.png",
+		"image": ["/logo.png", {"@type": "ImageObject", "url": "/logo.png"}],
+		"name": "/logo.png"
+	}]`
+
+	lr := linkRewrite{
+		"/about":    "/about/",
+		"/logo.png": "/logo.hash.png",
+	}
+
+	tf := transformJSONLinks(defaultLDPaths...)
+	out, err := tf(lr, []byte(ld))
+	c.Must.Nil(err)
+	c.Equal(string(out), ``+
+		`[{"@context":"https://schema.org","@type":"Organization",`+
+		`"url":"/about/","logo":"/logo.hash.png",`+
+		`"image":["/logo.hash.png",`+
+		`{"@type":"ImageObject","url":"/logo.hash.png"}],`+
+		`"name":"/logo.png"}]`)
+}
+
+func TestTransformJSONError(t *testing.T) {
+	c := check.New(t)
+
+	tf := transformJSONLinks(defaultLDPaths...)
+	_, err := tf(linkRewrite{}, []byte(`{"url": `))
+	c.NotNil(err)
+}
+
+func TestTransformJSONCustomLinks(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body: `<link rel="manifest" href="/data.json">` +
+					`<script type="application/ld+json">` +
+					`{"@type": "WebSite", "image": "img.gif"}` +
+					`</script>`,
+			},
+			"/data.json": stringHandler{
+				contType: jsonType,
+				body:     `{"items": [{"href": "img.gif"}]}`,
+			},
+			"/img.gif": stringHandler{
+				contType: testutil.GifType,
+				body:     string(testutil.GifBin),
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Fingerprint(func(u *url.URL, mediaType string) bool {
+			return filepath.Ext(u.Path) == ".gif"
+		}),
+		JSONLinks(jsonType, "items[].href"))
+	c.Must.Nil(err)
+
+	img := site.GetPage("/img.gif")
+	c.Must.NotNil(img)
+
+	c.Contains(tmp.ReadFile("/public/data.json"), img.URL.Path)
+	c.Contains(tmp.ReadFile("/public/index.html"), img.URL.Path)
+}
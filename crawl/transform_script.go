@@ -0,0 +1,256 @@
+package crawl
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/tdewolff/parse/v2/js"
+)
+
+type jsTransform struct {
+	toks  []jsToken
+	links map[int]jsLink // Token index -> link
+}
+
+type jsToken struct {
+	tt   js.TokenType
+	data []byte
+}
+
+type jsLink struct {
+	quote byte   // Quote char, or 0 for comments
+	pre   string // For comments: everything before the url
+	post  string // For comments: everything after the url
+	url   string
+	link  ResolvedLinker
+}
+
+var reJSSourceMap = regexp.MustCompile(
+	`^(//[#@]\s*sourceMappingURL=)(\S+)(.*)$`)
+
+func transformJS(lr LinkResolver, b []byte) ([]byte, error) {
+	tf, err := newJSTransform(lr, b)
+	if err != nil {
+		return nil, err
+	}
+
+	b, comments := tf.get()
+
+	// Minify last so that any quotes are normalized
+	b, err = Minify.Bytes(jsType, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Minifying drops comments, so any source map comments go back at the end,
+	// where they belong
+	if len(comments) > 0 {
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			b = append(b, '\n')
+		}
+
+		b = append(b, comments...)
+	}
+
+	return b, nil
+}
+
+// newJSTransform finds every static module specifier (import/export ... from,
+// bare import, dynamic import() with a string, `new URL(..., import.meta.url)`)
+// and source map comment, and resolves them.
+func newJSTransform(lr LinkResolver, b []byte) (*jsTransform, error) {
+	tf := &jsTransform{
+		links: make(map[int]jsLink),
+	}
+
+	l := js.NewLexer(bytes.NewReader(b))
+	for {
+		tt, data := l.Next()
+		if tt == js.ErrorToken {
+			if l.Err() != io.EOF {
+				return nil, l.Err()
+			}
+
+			break
+		}
+
+		// The lexer reuses its buffer
+		tf.toks = append(tf.toks, jsToken{
+			tt:   tt,
+			data: append([]byte(nil), data...),
+		})
+	}
+
+	var sig []int // Indexes of significant tokens
+	for i, tok := range tf.toks {
+		switch tok.tt {
+		case js.WhitespaceToken, js.LineTerminatorToken,
+			js.MultiLineCommentToken:
+
+		case js.SingleLineCommentToken:
+			m := reJSSourceMap.FindStringSubmatch(string(tok.data))
+			if m != nil {
+				tf.links[i] = jsLink{
+					pre:  m[1],
+					post: m[3],
+					url:  m[2],
+					link: lr.ResolveLink(m[2]),
+				}
+			}
+
+		default:
+			sig = append(sig, i)
+		}
+	}
+
+	for si, i := range sig {
+		if tf.toks[i].tt != js.StringToken {
+			continue
+		}
+
+		if !tf.isSpecifier(sig, si) {
+			continue
+		}
+
+		quote, url, ok := jsUnquote(tf.toks[i].data)
+		if !ok {
+			continue
+		}
+
+		tf.links[i] = jsLink{
+			quote: quote,
+			url:   url,
+			link:  lr.ResolveLink(url),
+		}
+	}
+
+	return tf, nil
+}
+
+// isSpecifier determines if the string at sig[si] is a module specifier (or an
+// asset URL relative to the module)
+func (tf *jsTransform) isSpecifier(sig []int, si int) bool {
+	is := func(off int, vals ...string) bool {
+		for i, val := range vals {
+			j := si + off + i
+			if j < 0 || j >= len(sig) || string(tf.toks[sig[j]].data) != val {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	_, url, _ := jsUnquote(tf.toks[sig[si]].data)
+
+	switch {
+	// import x from "..."; export { x } from "..."
+	case is(-1, "from"),
+		// import "..."
+		is(-1, "import"),
+		// import("...")
+		is(-2, "import", "(") && (is(1, ")") || is(1, ",")):
+
+		return isModuleURL(url)
+
+	// new URL("...", import.meta.url)
+	case is(-3, "new", "URL", "(") &&
+		is(1, ",", "import", ".", "meta", ".", "url"):
+
+		return true
+	}
+
+	return false
+}
+
+// isModuleURL determines if a module specifier is a URL: everything else is a
+// bare specifier (eg. "lodash") that only means something to import maps and
+// bundlers.
+func isModuleURL(spec string) bool {
+	return strings.HasPrefix(spec, "/") ||
+		strings.HasPrefix(spec, "./") ||
+		strings.HasPrefix(spec, "../") ||
+		strings.Contains(spec, "://")
+}
+
+// get gets the rewritten code. Source map comments are split out into their
+// own lines, since minifying would drop them.
+func (tf *jsTransform) get() (code, comments []byte) {
+	var b, cb bytes.Buffer
+
+	for i, tok := range tf.toks {
+		link, ok := tf.links[i]
+		if !ok {
+			b.Write(tok.data)
+			continue
+		}
+
+		rel := link.link.Get()
+		switch {
+		case link.quote == 0:
+			cb.WriteString(link.pre)
+			cb.WriteString(rel)
+			cb.WriteString(link.post)
+			cb.WriteString("\n")
+
+		case rel == link.url:
+			b.Write(tok.data)
+
+		default:
+			b.WriteString(jsQuote(link.quote, rel))
+		}
+	}
+
+	return b.Bytes(), cb.Bytes()
+}
+
+// jsUnquote unquotes a JS string literal. Since it's only used for URLs, it
+// doesn't bother with hex or unicode escapes: ok is false if it finds any.
+func jsUnquote(lit []byte) (quote byte, s string, ok bool) {
+	if len(lit) < 2 {
+		return
+	}
+
+	quote = lit[0]
+	lit = lit[1 : len(lit)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(lit); i++ {
+		c := lit[i]
+		if c == '\\' {
+			i++
+			if i >= len(lit) {
+				return
+			}
+
+			c = lit[i]
+			switch c {
+			case 'x', 'u', 'b', 'f', 'n', 'r', 't', 'v', '0', '\r', '\n':
+				return
+			}
+		}
+
+		b.WriteByte(c)
+	}
+
+	return quote, b.String(), true
+}
+
+func jsQuote(quote byte, s string) string {
+	var b strings.Builder
+
+	b.WriteByte(quote)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == quote || c == '\\' {
+			b.WriteByte('\\')
+		}
+
+		b.WriteByte(c)
+	}
+	b.WriteByte(quote)
+
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package crawl
+
+import (
+	"testing"
+
+	"github.com/thatguystone/cog/check"
+)
+
+func TestTransformJSRewrites(t *testing.T) {
+	c := check.New(t)
+
+	const js = `` +
+		`import a from "./a.js";` + "\n" +
+		`import { b } from '../b.js';` + "\n" +
+		`import "/c.js";` + "\n" +
+		`export * from "./a.js";` + "\n" +
+		`import lodash from "lodash";` + "\n" +
+		`const d = import("./d.js");` + "\n" +
+		`const img = new URL("img.gif", import.meta.url);` + "\n" +
+		`const notALink = "./a.js";` + "\n" +
+		`// import "./a.js";` + "\n" +
+		`const obj = { from: "./a.js" };`
+
+	lr := linkRewrite{
+		"./a.js":  "/a.hash.js",
+		"../b.js": "/b.hash.js",
+		"/c.js":   "/c.hash.js",
+		"./d.js":  "/d.hash.js",
+		"img.gif": "/img.hash.gif",
+		"lodash":  "/not-lodash.js",
+	}
+
+	out, err := transformJS(lr, []byte(js))
+	c.Must.Nil(err)
+
+	s := string(out)
+	c.Contains(s, `import a from "/a.hash.js"`)
+	c.Contains(s, `from '/b.hash.js'`)
+	c.Contains(s, `import "/c.hash.js"`)
+	c.Contains(s, `export*from "/a.hash.js"`)
+	c.Contains(s, `import("/d.hash.js")`)
+	c.Contains(s, `new URL("/img.hash.gif",import.meta.url)`)
+	c.Contains(s, `from "lodash"`)
+	c.Contains(s, `notALink="./a.js"`)
+	c.Contains(s, `from:"./a.js"`)
+	c.NotContains(s, `not-lodash`)
+}
+
+func TestTransformJSSourceMap(t *testing.T) {
+	c := check.New(t)
+
+	const js = "" +
+		"var a  =  1;\n" +
+		"//# sourceMappingURL=app.js.map  trailing\n" +
+		"var b  =  2;\n"
+
+	lr := linkRewrite{
+		"app.js.map": "/app.hash.js.map",
+	}
+
+	out, err := transformJS(lr, []byte(js))
+	c.Must.Nil(err)
+
+	c.Equal(string(out), ""+
+		"var a=1;var b=2;\n"+
+		"//# sourceMappingURL=/app.hash.js.map  trailing\n")
+}
+
+func TestJSUnquote(t *testing.T) {
+	c := check.New(t)
+
+	tests := []struct {
+		in    string
+		quote byte
+		out   string
+		ok    bool
+	}{
+		{`"./a.js"`, '"', "./a.js", true},
+		{`'./a\'b.js'`, '\'', "./a'b.js", true},
+		{`"./a\x41.js"`, 0, "", false},
+		{`"`, 0, "", false},
+	}
+
+	for _, test := range tests {
+		quote, out, ok := jsUnquote([]byte(test.in))
+		c.Equal(ok, test.ok, test.in)
+		c.Equal(out, test.out, test.in)
+
+		if ok {
+			c.Equal(quote, test.quote, test.in)
+			c.Equal(jsQuote(quote, out), test.in)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package crawl
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"strings"
+
+	"github.com/tdewolff/parse/v2/xml"
+)
+
+type svgTransform struct {
+	toks  []string
+	links map[int]func() string // Token index -> rewritten token
+}
+
+func transformSVG(lr LinkResolver, b []byte) ([]byte, error) {
+	tf, err := newSVGTransform(lr, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// Minify last so that rewritten attributes get requoted
+	return Minify.Bytes(svgType, tf.get())
+}
+
+// newSVGTransform finds every href, xlink:href, style="" and <style> in an SVG
+// document and resolves the links in them.
+func newSVGTransform(lr LinkResolver, b []byte) (*svgTransform, error) {
+	lr = svgLinkResolver{lr}
+
+	tf := &svgTransform{
+		links: make(map[int]func() string),
+	}
+
+	var (
+		l       = xml.NewLexer(bytes.NewReader(b))
+		tag     string // Name of the last start tag
+		inStyle bool   // Between <style> and </style>
+	)
+
+	for {
+		tt, data := l.Next()
+		if tt == xml.ErrorToken {
+			if l.Err() != io.EOF {
+				return nil, l.Err()
+			}
+
+			break
+		}
+
+		i := len(tf.toks)
+		tok := string(data)
+		tf.toks = append(tf.toks, tok)
+
+		switch tt {
+		case xml.StartTagToken:
+			tag = svgLocalName(string(l.Text()))
+
+		case xml.StartTagCloseToken:
+			inStyle = tag == "style"
+
+		case xml.EndTagToken:
+			inStyle = false
+
+		case xml.AttributeToken:
+			val := l.AttrVal()
+			if len(val) == 0 {
+				break
+			}
+
+			quote := byte('"')
+			if val[0] == '"' || val[0] == '\'' {
+				quote = val[0]
+				val = bytes.TrimSuffix(val[1:], val[:1])
+			}
+
+			pre := tok[:len(tok)-len(l.AttrVal())]
+			tf.addAttr(i, lr, string(l.Text()), pre, quote, string(val))
+
+		case xml.TextToken:
+			if inStyle {
+				css := newCSSTransform(lr, html.UnescapeString(tok))
+				tf.links[i] = func() string {
+					return svgEscape(string(css.get()), 0)
+				}
+			}
+
+		case xml.CDATAToken:
+			if inStyle {
+				css := newCSSTransform(lr, string(l.Text()))
+				tf.links[i] = func() string {
+					return "<![CDATA[" + string(css.get()) + "]]>"
+				}
+			}
+		}
+	}
+
+	return tf, nil
+}
+
+func (tf *svgTransform) addAttr(
+	i int, lr LinkResolver, name, pre string, quote byte, val string) {
+
+	val = html.UnescapeString(val)
+	attr := func(val string) string {
+		return pre + string(quote) + svgEscape(val, quote) + string(quote)
+	}
+
+	switch name {
+	case "href", "xlink:href":
+		res := lr.ResolveLink(val)
+		tf.links[i] = func() string {
+			return attr(res.Get())
+		}
+
+	case "style":
+		css := newCSSTransform(lr, val)
+		tf.links[i] = func() string {
+			return attr(string(css.get()))
+		}
+	}
+}
+
+// svgLinkResolver leaves fragment-only links (eg. <use href="#icon">) alone.
+// They point into the SVG itself, whose final URL isn't known until it's done
+// being transformed, and they work as-is wherever it ends up.
+type svgLinkResolver struct {
+	LinkResolver
+}
+
+func (lr svgLinkResolver) ResolveLink(link string) ResolvedLinker {
+	if strings.HasPrefix(link, "#") {
+		return unresolvedLink(link)
+	}
+
+	return lr.LinkResolver.ResolveLink(link)
+}
+
+func (tf *svgTransform) get() []byte {
+	var b strings.Builder
+
+	for i, tok := range tf.toks {
+		if link, ok := tf.links[i]; ok {
+			tok = link()
+		}
+
+		b.WriteString(tok)
+	}
+
+	return []byte(b.String())
+}
+
+func svgLocalName(name string) string {
+	if i := strings.IndexByte(name, ':'); i != -1 {
+		name = name[i+1:]
+	}
+
+	return name
+}
+
+// svgEscape escapes text for use in XML. If quote is non-zero, the text is
+// escaped for use in an attribute value surrounded by quote.
+func svgEscape(s string, quote byte) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '&':
+			b.WriteString("&amp;")
+
+		case c == '<':
+			b.WriteString("&lt;")
+
+		case c == '"' && quote == '"':
+			b.WriteString("&quot;")
+
+		case c == '\'' && quote == '\'':
+			b.WriteString("&apos;")
+
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestTransformSVGRewrites(t *testing.T) {
+	c := check.New(t)
+
+	lr := linkRewrite{
+		"/img.gif":       "/img.hash.gif",
+		"sprite.svg#a&b": "/sprite.hash.svg#a&b",
+		"#local":         "/self.hash.svg#local",
+	}
+
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{
+			in:  `<svg><image href="/img.gif"/></svg>`,
+			out: `<image href="/img.hash.gif"/>`,
+		},
+		{
+			in:  `<svg><use xlink:href='sprite.svg#a&amp;b'/></svg>`,
+			out: `<use xlink:href="/sprite.hash.svg#a&amp;b"/>`,
+		},
+		{
+			in:  `<svg><a href="/img.gif"><path d="M0 0"/></a></svg>`,
+			out: `<a href="/img.hash.gif">`,
+		},
+		{
+			in:  `<svg><style>rect { fill: url(/img.gif) }</style></svg>`,
+			out: `url(/img.hash.gif)`,
+		},
+		{
+			in:  `<svg><style><![CDATA[a > b { fill: url(/img.gif) }]]></style></svg>`,
+			out: `url(/img.hash.gif)`,
+		},
+		{
+			in:  `<svg><rect style="fill: url('/img.gif')"/></svg>`,
+			out: `/img.hash.gif`,
+		},
+		{
+			// Fragment-only links point into the SVG itself
+			in:  `<svg><use href="#local"/></svg>`,
+			out: `<use href="#local"/>`,
+		},
+		{
+			in:  `<svg><rect style="fill: url(#local)"/></svg>`,
+			out: `url(#local)`,
+		},
+		{
+			in:  `<svg><text>url(/img.gif)</text></svg>`,
+			out: `url(/img.gif)`,
+		},
+	}
+
+	for _, test := range tests {
+		out, err := transformSVG(lr, []byte(test.in))
+		c.Must.Nil(err, test.in)
+		c.Contains(string(out), test.out, test.in)
+	}
+}
+
+func TestTransformSVGCrawl(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<img src="/img/icon.svg">`,
+			},
+			"/img/icon.svg": stringHandler{
+				contType: svgType,
+				body: `<svg xmlns="http://www.w3.org/2000/svg">` +
+					`<defs><linearGradient id="g"/></defs>` +
+					`<use href="sprite.svg#icon" fill="url(#g)"/>` +
+					`<image href="img.gif"/>` +
+					`</svg>`,
+			},
+			"/img/sprite.svg": stringHandler{
+				contType: svgType,
+				body: `<svg xmlns="http://www.w3.org/2000/svg">` +
+					`<symbol id="icon"><use href="#other"/></symbol>` +
+					`</svg>`,
+			},
+			"/img/img.gif": stringHandler{
+				contType: testutil.GifType,
+				body:     string(testutil.GifBin),
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Fingerprint(func(u *url.URL, mediaType string) bool {
+			return filepath.Ext(u.Path) != ".html" && u.Path != "/"
+		}))
+	c.Must.Nil(err)
+
+	icon := site.GetPage("/img/icon.svg")
+	sprite := site.GetPage("/img/sprite.svg")
+	img := site.GetPage("/img/img.gif")
+	c.Must.NotNil(sprite)
+	c.Must.NotNil(img)
+
+	out := tmp.ReadFile(filepath.Join("/public", icon.URL.Path))
+	c.Contains(out, sprite.URL.Path+"#icon")
+	c.Contains(out, img.URL.Path)
+	c.Contains(out, "url(#g)")
+
+	c.Contains(tmp.ReadFile(filepath.Join("/public", sprite.URL.Path)), `#other`)
+}
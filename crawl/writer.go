@@ -0,0 +1,334 @@
+package crawl
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A DryRunReport describes every change a crawl would have made to the
+// filesystem. All paths are absolute.
+type DryRunReport struct {
+	Created   []string // Files that would be written that don't exist
+	Changed   []string // Existing files whose contents would change
+	Symlinked []string // Symlinks that would be created or repointed
+	Removed   []string // Files and dirs the clean step would remove
+
+	// Entries that would be dropped from the fingerprint cache: files, and
+	// generated bodies as "url:" followed by their URL
+	FingerprintsRemoved []string
+}
+
+// outputWriter makes every change the crawler makes to the filesystem so that
+// a dry run can record the changes instead. Paths are absolute: those under the
+// output dir go to the OutputFS, and everything else (eg. CleanDirs) goes to the
+// local filesystem.
+type outputWriter struct {
+	root   string   // Absolute path of the output dir
+	fs     OutputFS // Everything under root
+	local  OutputFS // Everything else
+	dryRun bool
+	events *eventer
+
+	mtx    sync.Mutex
+	report DryRunReport
+}
+
+func (w *outputWriter) init(root string, events *eventer) {
+	w.root = absPath(root)
+	w.events = events
+	w.local = dirOutput(string(filepath.Separator))
+
+	if w.fs == nil {
+		w.fs = dirOutput(w.root)
+	}
+}
+
+// resolve gets the OutputFS and name for the given absolute path
+func (w *outputWriter) resolve(path string) (OutputFS, string) {
+	if rel, ok := w.inOutput(path); ok {
+		return w.fs, rel
+	}
+
+	rel := strings.TrimPrefix(path, string(filepath.Separator))
+	return w.local, filepath.ToSlash(rel)
+}
+
+// inOutput determines if the given absolute path is under the output dir, and
+// gets its name in the OutputFS if so
+func (w *outputWriter) inOutput(path string) (string, bool) {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil ||
+		rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+
+		return "", false
+	}
+
+	return filepath.ToSlash(rel), true
+}
+
+// path is the inverse of resolve
+func (w *outputWriter) path(inOutput bool, name string) string {
+	root := w.root
+	if !inOutput {
+		root = string(filepath.Separator)
+	}
+
+	return filepath.Join(root, filepath.FromSlash(name))
+}
+
+func (w *outputWriter) record(list *[]string, path string) {
+	w.mtx.Lock()
+	*list = append(*list, path)
+	w.mtx.Unlock()
+}
+
+// writeFile writes b to path. If the file hasn't changed, nothing is written:
+// this is mainly for rsync.
+func (w *outputWriter) writeFile(path string, b []byte) error {
+	_, err := w.updateFile(path, b)
+	return err
+}
+
+// updateFile is writeFile, but it also reports if the file changed (or would
+// have, in a dry run)
+func (w *outputWriter) updateFile(path string, b []byte) (changed bool, err error) {
+	fs, name := w.resolve(path)
+
+	equal, err := fs.Equal(name, b)
+	if err != nil {
+		return false, err
+	}
+
+	if equal {
+		w.unchanged(path)
+		return false, nil
+	}
+
+	if w.dryRun {
+		return true, w.recordWrite(fs, name, path)
+	}
+
+	err = fs.WriteFile(name, b)
+	if err == nil {
+		w.events.emit(Event{Kind: EventWritten, Path: path})
+	}
+
+	return true, err
+}
+
+// moveFile moves src, a file on the local filesystem, to path. Like writeFile,
+// if the file hasn't changed, nothing is moved. Returns if src was moved, and
+// like updateFile, if the file changed.
+//
+// Only local output can take the file as-is; anything else gets a copy of its
+// contents.
+func (w *outputWriter) moveFile(src, path string) (moved, changed bool, err error) {
+	fs, name := w.resolve(path)
+
+	d, ok := fs.(dirOutput)
+	if !ok {
+		changed, err = w.copyFile(fs, src, name, path)
+		return false, changed, err
+	}
+
+	equal, err := filesEqual(d.path(name), src)
+	if err != nil {
+		return false, false, err
+	}
+
+	if equal {
+		w.unchanged(path)
+		return false, false, nil
+	}
+
+	if w.dryRun {
+		return false, true, w.recordWrite(fs, name, path)
+	}
+
+	err = d.moveFile(src, name)
+	if err != nil {
+		return false, true, err
+	}
+
+	w.events.emit(Event{Kind: EventWritten, Path: path})
+	return true, true, nil
+}
+
+// copyFile copies src, a file on the local filesystem, to name in fs. If fs is
+// an OutputStreamer, src is streamed to it. Comparing with Equal would mean
+// reading all of src into memory, so streamed files are always written.
+func (w *outputWriter) copyFile(fs OutputFS, src, name, path string) (
+	changed bool, err error) {
+
+	s, ok := fs.(OutputStreamer)
+	if !ok {
+		b, err := ioutil.ReadFile(src)
+		if err != nil {
+			return false, err
+		}
+
+		return w.updateFile(path, b)
+	}
+
+	if w.dryRun {
+		return true, w.recordWrite(fs, name, path)
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	err = s.WriteFrom(name, info.Size(), f)
+	if err == nil {
+		w.events.emit(Event{Kind: EventWritten, Path: path})
+	}
+
+	return true, err
+}
+
+// keepFile leaves the file at path as it is, if there is one. Returns if there
+// was.
+func (w *outputWriter) keepFile(path string) (bool, error) {
+	fs, name := w.resolve(path)
+
+	exists, err := fs.Exists(name)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	w.unchanged(path)
+	return true, nil
+}
+
+func (w *outputWriter) unchanged(path string) {
+	if !w.dryRun {
+		w.events.emit(Event{Kind: EventUnchanged, Path: path})
+	}
+}
+
+// recordWrite records a write to path in the dry run report
+func (w *outputWriter) recordWrite(fs OutputFS, name, path string) error {
+	exists, err := fs.Exists(name)
+	if err != nil {
+		return err
+	}
+
+	list := &w.report.Created
+	if exists {
+		list = &w.report.Changed
+	}
+
+	w.record(list, path)
+	return nil
+}
+
+// canRead determines if the file at path exists and can be read back
+func (w *outputWriter) canRead(path string) bool {
+	fs, name := w.resolve(path)
+
+	if _, ok := fs.(OutputReader); !ok {
+		return false
+	}
+
+	exists, err := fs.Exists(name)
+	return err == nil && exists
+}
+
+func (w *outputWriter) readFile(path string) ([]byte, error) {
+	fs, name := w.resolve(path)
+
+	r, ok := fs.(OutputReader)
+	if !ok {
+		return nil, errors.New("output can't be read back")
+	}
+
+	return r.ReadFile(name)
+}
+
+func (w *outputWriter) symlink(src, path string) error {
+	src = absPath(src)
+	fs, name := w.resolve(path)
+
+	if w.dryRun {
+		if to, err := fs.Readlink(name); err != nil || to != src {
+			w.record(&w.report.Symlinked, path)
+		}
+
+		return nil
+	}
+
+	err := fs.Symlink(src, name)
+	if err == nil {
+		w.events.emit(Event{Kind: EventWritten, Path: path})
+	}
+
+	return err
+}
+
+func (w *outputWriter) walk(dir string, fn func(path string, isDir bool) error) error {
+	fs, root := w.resolve(dir)
+	_, inOutput := w.inOutput(dir)
+
+	return fs.Walk(root, func(name string, isDir bool) error {
+		return fn(w.path(inOutput, name), isDir)
+	})
+}
+
+func (w *outputWriter) removeAll(path string) error {
+	if w.dryRun {
+		w.record(&w.report.Removed, path)
+		return nil
+	}
+
+	fs, name := w.resolve(path)
+
+	err := fs.RemoveAll(name)
+	if err == nil {
+		w.events.emit(Event{Kind: EventCleaned, Path: path})
+	}
+
+	return err
+}
+
+func (w *outputWriter) removeFingerprint(path string) {
+	if w.dryRun {
+		w.record(&w.report.FingerprintsRemoved, path)
+	}
+}
+
+// getReport gets the report of a dry run, or nil if this isn't one
+func (w *outputWriter) getReport() *DryRunReport {
+	if !w.dryRun {
+		return nil
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	report := w.report
+	for _, list := range [][]string{
+		report.Created,
+		report.Changed,
+		report.Symlinked,
+		report.Removed,
+		report.FingerprintsRemoved,
+	} {
+		sort.Strings(list)
+	}
+
+	return &report
+}
@@ -0,0 +1,135 @@
+package crawl
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/thatguystone/acrylic/internal/testutil"
+	"github.com/thatguystone/cog/check"
+)
+
+func TestWriterDryRun(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, map[string]string{
+		"/old.txt": `old`,
+		"/new.txt": `new`,
+	})
+	defer tmp.Remove()
+
+	handler := func(index string, files ...string) http.Handler {
+		m := map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     index,
+			},
+		}
+
+		for _, file := range files {
+			file := file
+			m[file] = http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					ServeFile(w, r, tmp.Path(file))
+				})
+		}
+
+		return mux(m)
+	}
+
+	opts := []Option{
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Fingerprint(func(u *url.URL, mediaType string) bool {
+			return filepath.Ext(u.Path) == ".txt"
+		}),
+	}
+
+	site, err := Crawl(
+		handler(`<a href="/old.txt">`, "/old.txt"),
+		opts...)
+	c.Must.Nil(err)
+	c.True(site.DryRunReport() == nil)
+
+	oldPath := site.GetPage("/old.txt").OutputPath
+	cache := tmp.ReadFile(".cache/fingerprints")
+
+	// Stale files that a real run would clean up
+	err = ioutil.WriteFile(tmp.Path("/public/stale.txt"), nil, 0666)
+	c.Must.Nil(err)
+	err = os.MkdirAll(tmp.Path("/public/stale/dir"), 0777)
+	c.Must.Nil(err)
+
+	site, err = Crawl(
+		handler(`<a href="/new.txt">`, "/new.txt"),
+		append(opts, DryRun())...)
+	c.Must.Nil(err)
+	tmp.DumpTree()
+
+	newPath := site.GetPage("/new.txt").OutputPath
+
+	report := site.DryRunReport()
+	c.Must.NotNil(report)
+	c.Equal(report.Created, []string(nil))
+	c.Equal(report.Changed, []string{tmp.Path("/public/index.html")})
+	c.Equal(report.Symlinked, []string{newPath})
+	c.Equal(report.Removed, []string{
+		oldPath,
+		tmp.Path("/public/stale"),
+		tmp.Path("/public/stale.txt"),
+	})
+	c.Equal(report.FingerprintsRemoved, []string{tmp.Path("/old.txt")})
+
+	// Nothing should have changed
+	c.Contains(tmp.ReadFile("/public/index.html"), "/old.")
+	c.Equal(tmp.ReadFile(".cache/fingerprints"), cache)
+	_, err = os.Stat(tmp.Path("/public/stale.txt"))
+	c.Nil(err)
+	_, err = os.Stat(tmp.Path("/public/stale/dir"))
+	c.Nil(err)
+	_, err = os.Lstat(newPath)
+	c.True(os.IsNotExist(err))
+}
+
+func TestWriterDryRunCreated(t *testing.T) {
+	c := check.New(t)
+
+	tmp := testutil.NewTmpDir(c, nil)
+	defer tmp.Remove()
+
+	site, err := Crawl(
+		mux(map[string]http.Handler{
+			"/": stringHandler{
+				contType: htmlType,
+				body:     `<link href="/all.css" rel="stylesheet">`,
+			},
+			"/all.css": stringHandler{
+				contType: cssType,
+				body:     `body { background: #000; }`,
+			},
+		}),
+		Output(tmp.Path("/public")),
+		FingerprintCache(tmp.Path(".cache/fingerprints")),
+		Precompress([]Compressor{Gzip()}, 0, nil),
+		Manifest(tmp.Path("/manifest.json")),
+		DryRun())
+	c.Must.Nil(err)
+
+	report := site.DryRunReport()
+	c.Must.NotNil(report)
+	c.Equal(report.Created, []string{
+		tmp.Path("/manifest.json"),
+		tmp.Path("/public/all.css"),
+		tmp.Path("/public/all.css.gz"),
+		tmp.Path("/public/index.html"),
+		tmp.Path("/public/index.html.gz"),
+	})
+
+	_, err = os.Stat(tmp.Path("/public"))
+	c.True(os.IsNotExist(err))
+	_, err = os.Stat(tmp.Path("/manifest.json"))
+	c.True(os.IsNotExist(err))
+}
@@ -3,9 +3,11 @@ module github.com/thatguystone/acrylic
 go 1.12
 
 require (
+	github.com/andybalholm/brotli v1.0.0
 	github.com/goji/param v0.0.0-20160927210335-d7f49fd7d1ed
 	github.com/rjeczalik/notify v0.9.2
 	github.com/tdewolff/minify/v2 v2.3.8
+	github.com/tdewolff/parse/v2 v2.3.5
 	github.com/thatguystone/cog v0.0.0-20190206201955-cb23b13a7afb
 	github.com/wellington/go-libsass v0.9.2
 	golang.org/x/net v0.0.0-20190206173232-65e2d4e15006